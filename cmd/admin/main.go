@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	types "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/mapofzones/txs-processor/pkg/x/postgres"
+)
+
+const usage = `usage: admin <command> [flags]
+
+commands:
+  rollback -zone <chain id> -height <height>    removes data of zone blocks above the height
+  bootstrap -zone <chain id> -height <height>   starts processing of the zone from the height
+  uptime -zone <chain id> -channel <channel id>  prints channel state history and uptime
+  active-addresses -zones <chain ids> -from <time> -to <time>
+                                                 estimates distinct active addresses of sketch zones
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "rollback":
+		flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+		zone := flags.String("zone", "", "chain ID of the zone")
+		height := flags.Int64("height", -1, "height of the last block to keep")
+		_ = flags.Parse(os.Args[2:])
+		if *zone == "" || *height < 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		// retention must match the one of processors, older blocks have no undo queries
+		retention, _ := strconv.ParseInt(os.Getenv("rollback_retention"), 10, 64)
+		db := connect(ctx, postgres.WithRollbackRetention(retention))
+		if err := db.Rollback(ctx, *zone, *height); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is rolled back to height %d\n", *zone, *height)
+	case "bootstrap":
+		flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+		zone := flags.String("zone", "", "chain ID of the zone")
+		height := flags.Int64("height", 0, "height of the first block to process")
+		_ = flags.Parse(os.Args[2:])
+		if *zone == "" || *height < 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		db := connect(ctx)
+		if err := db.Bootstrap(ctx, *zone, *height); err != nil {
+			log.Fatal(err)
+		}
+		// bootstrapped height wins, but configuration which says otherwise is likely a mistake
+		if heights, err := types.ParseStartHeights(os.Getenv("start_heights")); err == nil {
+			if configured, ok := heights[*zone]; ok && configured != *height {
+				log.Printf("warning: start_heights configures height %d for %s, it is ignored in favour of the bootstrapped one\n", configured, *zone)
+			}
+		}
+		log.Printf("%s will be processed from height %d\n", *zone, *height)
+	case "uptime":
+		flags := flag.NewFlagSet("uptime", flag.ExitOnError)
+		zone := flags.String("zone", "", "chain ID of the zone")
+		channel := flags.String("channel", "", "channel ID")
+		_ = flags.Parse(os.Args[2:])
+		if *zone == "" || *channel == "" {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		db := connect(ctx)
+		events, err := db.ChannelEvents(ctx, *channel, *zone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, e := range events {
+			fmt.Printf("%d\t%s\t%s\t%s\n", e.Height, e.Time.Format(time.RFC3339), e.State, e.TxHash)
+		}
+		fmt.Printf("uptime: %s\n", types.ChannelUptime(events, time.Now()))
+	case "active-addresses":
+		flags := flag.NewFlagSet("active-addresses", flag.ExitOnError)
+		zones := flags.String("zones", "", "comma separated chain IDs of zones which store sketches")
+		from := flags.String("from", "", "start of the period, RFC3339")
+		to := flags.String("to", "", "end of the period, RFC3339, defaults to now")
+		_ = flags.Parse(os.Args[2:])
+		start, err := time.Parse(time.RFC3339, *from)
+		if *zones == "" || err != nil {
+			flags.Usage()
+			os.Exit(2)
+		}
+		end := time.Now()
+		if *to != "" {
+			if end, err = time.Parse(time.RFC3339, *to); err != nil {
+				flags.Usage()
+				os.Exit(2)
+			}
+		}
+
+		db := connect(ctx)
+		count, err := db.ActiveAddresses(ctx, strings.Split(*zones, ","), start.UTC(), end.UTC())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("active addresses: %d\n", count)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// connect returns processor with a single db connection, commands exit if it can't be opened
+func connect(ctx context.Context, opts ...postgres.Option) *postgres.PostgresProcessor {
+	db, err := postgres.Connect(ctx, os.Getenv("postgres"), 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return postgres.NewProcessor(db, opts...)
+}
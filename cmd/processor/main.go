@@ -4,29 +4,140 @@ import (
 	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	processor "github.com/mapofzones/txs-processor/pkg"
+	"github.com/mapofzones/txs-processor/pkg/middleware"
 	"github.com/mapofzones/txs-processor/pkg/rabbitmq"
+	types "github.com/mapofzones/txs-processor/pkg/types"
 	"github.com/mapofzones/txs-processor/pkg/x/postgres"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	blocks, err := rabbitmq.BlockStream(ctx, os.Getenv("rabbitmq"), "hackatom_blocks_v2")
+	// blocks are acknowledged once stored, so chain prefetch limits how many blocks of each chain are in memory
+	prefetch, _ := strconv.Atoi(os.Getenv("prefetch"))
+	chainPrefetch, _ := strconv.Atoi(os.Getenv("chain_prefetch"))
+	// replicas need their own queues bound to the same exchange, blocks of a shared queue would be split between them
+	queue := os.Getenv("rabbitmq_queue")
+	if queue == "" {
+		queue = "hackatom_blocks_v2"
+	}
+	blocks, err := rabbitmq.BlockStream(ctx, os.Getenv("rabbitmq"), os.Getenv("rabbitmq_exchange"), queue, prefetch, chainPrefetch)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	concurrency := processor.DefaultConcurrency
+	if n, err := strconv.Atoi(os.Getenv("concurrency")); err == nil && n > 0 {
+		concurrency = n
+	}
+	// processors of all chains share connections, each block being processed needs one of them
+	db, err := postgres.Connect(ctx, os.Getenv("postgres"), concurrency)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	db, err := postgres.NewProcessor(ctx, os.Getenv("postgres"))
+	opts := []postgres.Option{}
+	// busy chains can store hyperloglog sketches instead of every active address
+	sketchZones, err := types.ParseChainPatterns(os.Getenv("sketch_zones"))
 	if err != nil {
 		log.Fatal(err)
 	}
+	if os.Getenv("address_mode") == "sketch" {
+		sketchZones = []string{"*"}
+	}
+	opts = append(opts, postgres.WithSketchZones(sketchZones))
+
+	if threshold, err := time.ParseDuration(os.Getenv("caught_up_threshold")); err == nil {
+		opts = append(opts, postgres.WithCaughtUpThreshold(threshold))
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("channel_cache_size")); err == nil {
+		opts = append(opts, postgres.WithChannelCacheSize(size))
+	}
 
-	processor := processor.NewProcessor(ctx, blocks, db)
+	// while catching up blocks can be committed in batches
+	if blocks, err := strconv.Atoi(os.Getenv("batch_blocks")); err == nil {
+		interval, _ := time.ParseDuration(os.Getenv("batch_interval"))
+		opts = append(opts, postgres.WithBatching(blocks, interval))
+	}
+
+	if retention, err := strconv.ParseInt(os.Getenv("rollback_retention"), 10, 64); err == nil {
+		opts = append(opts, postgres.WithRollbackRetention(retention))
+	}
+
+	// chains which are indexed from the middle of their history
+	startHeights, err := types.ParseStartHeights(os.Getenv("start_heights"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, postgres.WithStartHeights(startHeights))
+
+	disabled, err := types.ParseDisabledHandlers(os.Getenv("disabled_handlers"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// handlers can skip messages of chains whose blocks are still processed
+	handled, err := types.NewChainFilter(os.Getenv("handle_chains_allow"), os.Getenv("handle_chains_deny"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	metrics := middleware.NewMetrics()
+	go metrics.Report(ctx, time.Minute)
+	middlewares := handlerMiddlewares(metrics, handled)
+
+	// every chain gets its own processor with separate block state
+	newProcessor := func(ctx context.Context) (types.Processor, error) {
+		p := postgres.NewProcessor(db, opts...)
+		p.Registry().Use(middlewares...)
+		p.Registry().OnUnknown(metrics.CountUnknown)
+
+		for _, h := range disabled {
+			if err := p.Registry().DisableForChains(h.MsgType, h.Name, h.Chain); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+
+	chains, err := types.NewChainFilter(os.Getenv("chains_allow"), os.Getenv("chains_deny"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	processor := processor.NewProcessor(ctx, blocks, newProcessor)
+	processor.Chains = chains
+	processor.Concurrency = concurrency
 
 	err = processor.Process(ctx)
 
 	cancel()
+	_ = db.Close(context.Background())
 	log.Fatal(err)
 }
+
+// handlerMiddlewares configures middlewares wrapping every message handler
+func handlerMiddlewares(metrics *middleware.Metrics, handled types.ChainFilter) []types.Middleware {
+	middlewares := []types.Middleware{
+		middleware.Trace(),
+		middleware.Recover(),
+	}
+
+	if len(handled.Allow)+len(handled.Deny) > 0 {
+		middlewares = append(middlewares, middleware.FilterChains(handled))
+	}
+	middlewares = append(middlewares, metrics.Middleware())
+
+	if threshold, err := time.ParseDuration(os.Getenv("slow_handler")); err == nil {
+		middlewares = append(middlewares, middleware.Timing(threshold))
+	}
+
+	if rate, err := strconv.ParseUint(os.Getenv("debug_sample"), 10, 64); err == nil {
+		middlewares = append(middlewares, middleware.Sample(rate))
+	}
+
+	return middlewares
+}
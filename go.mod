@@ -5,6 +5,7 @@ go 1.13
 replace github.com/gogo/protobuf => github.com/regen-network/protobuf v1.3.2-alpha.regen.4
 
 require (
+	github.com/jackc/pgconn v1.5.0
 	github.com/jackc/pgx/v4 v4.6.0
 	github.com/mapofzones/cosmos-watcher v0.0.0-20210303220701-2654f0609690
 	github.com/streadway/amqp v0.0.0-20200108173154-1c71cc93ed71
@@ -0,0 +1,119 @@
+// Package hyperloglog implements HyperLogLog cardinality estimation
+// http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf
+package hyperloglog
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision is the number of hash bits used to pick register,
+// 2^14 registers give ~0.8% standard error and take 16KB
+const Precision = 14
+
+var ErrPrecisionMismatch = errors.New("sketches have different precision")
+
+var ErrInvalidSketch = errors.New("invalid sketch data")
+
+// Sketch holds registers of HyperLogLog estimator
+type Sketch struct {
+	precision uint8
+	registers []uint8
+}
+
+// New returns empty sketch with default precision
+func New() *Sketch {
+	return &Sketch{
+		precision: Precision,
+		registers: make([]uint8, 1<<Precision),
+	}
+}
+
+// Insert adds value to the sketch
+func (s *Sketch) Insert(value string) {
+	x := hash(value)
+	index := x >> (64 - s.precision)
+	// make sure that rank doesn't exceed 64-precision+1
+	w := x<<s.precision | 1<<(s.precision-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge combines other sketch into this one, result estimates cardinality of union
+func (s *Sketch) Merge(other *Sketch) error {
+	if s.precision != other.precision {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Estimate returns approximate number of distinct values inserted into the sketch
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(m) * m * m / sum
+	// small range correction
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// MarshalBinary encodes sketch as precision byte followed by registers
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, len(s.registers)+1)
+	data = append(data, s.precision)
+	return append(data, s.registers...), nil
+}
+
+// UnmarshalBinary decodes sketch encoded by MarshalBinary
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 || data[0] < 4 || data[0] > 18 || len(data)-1 != 1<<data[0] {
+		return ErrInvalidSketch
+	}
+	s.precision = data[0]
+	s.registers = append([]uint8(nil), data[1:]...)
+	return nil
+}
+
+func alpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/m)
+	}
+}
+
+// hash is fnv-1a with murmur3 finalizer, fnv alone does not spread similar strings well enough
+func hash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
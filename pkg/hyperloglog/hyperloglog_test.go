@@ -0,0 +1,65 @@
+package hyperloglog
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSketch_Estimate(t *testing.T) {
+	tests := []struct {
+		name     string
+		distinct int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"medium", 10000},
+		{"large", 200000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			for i := 0; i < tt.distinct; i++ {
+				// every value is inserted twice, duplicates must not be counted
+				s.Insert(fmt.Sprintf("cosmos1address%d", i))
+				s.Insert(fmt.Sprintf("cosmos1address%d", i))
+			}
+			assert.InDelta(t, tt.distinct, s.Estimate(), math.Max(2, 0.03*float64(tt.distinct)))
+		})
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 6000; i++ {
+		a.Insert(fmt.Sprintf("address%d", i))
+	}
+	for i := 4000; i < 10000; i++ {
+		b.Insert(fmt.Sprintf("address%d", i))
+	}
+
+	assert.NoError(t, a.Merge(b))
+	assert.InDelta(t, 10000, a.Estimate(), 300)
+
+	other := &Sketch{precision: 4, registers: make([]uint8, 16)}
+	assert.Equal(t, ErrPrecisionMismatch, a.Merge(other))
+}
+
+func TestSketch_Binary(t *testing.T) {
+	s := New()
+	for i := 0; i < 1000; i++ {
+		s.Insert(fmt.Sprintf("address%d", i))
+	}
+
+	data, err := s.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &Sketch{}
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, s, decoded)
+
+	assert.Equal(t, ErrInvalidSketch, decoded.UnmarshalBinary(nil))
+	assert.Equal(t, ErrInvalidSketch, decoded.UnmarshalBinary(data[:100]))
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// HandlerStats are metrics of handlers of one message type
+type HandlerStats struct {
+	Count    int
+	Errors   int
+	Duration time.Duration
+	// messages of the type which had no handlers
+	Unknown int
+	// messages rejected because of invalid state transition, they are not counted as errors
+	Rejected int
+}
+
+// Metrics gathers per message type metrics of handlers
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[string]HandlerStats
+}
+
+// NewMetrics returns empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{stats: make(map[string]HandlerStats)}
+}
+
+type nestedKey struct{}
+
+// Middleware returns middleware which records metrics of every handled message,
+// time of handlers called from inside of another handler is not counted twice
+func (m *Metrics) Middleware() processor.Middleware {
+	return func(next processor.HandlerFunc) processor.HandlerFunc {
+		return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			nested := new(time.Duration)
+			start := time.Now()
+			err := next(context.WithValue(ctx, nestedKey{}, nested), metadata, msg)
+			total := time.Since(start)
+			elapsed := total - *nested
+			if parent, ok := ctx.Value(nestedKey{}).(*time.Duration); ok {
+				*parent += total
+			}
+
+			m.mu.Lock()
+			stats := m.stats[msg.Type()]
+			stats.Count++
+			stats.Duration += elapsed
+			switch {
+			case errors.Is(err, processor.StateTransitionError):
+				stats.Rejected++
+			case err != nil:
+				stats.Errors++
+			}
+			m.stats[msg.Type()] = stats
+			m.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// CountUnknown records message of type without handlers, it is meant to be registry hook
+func (m *Metrics) CountUnknown(msgType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats[msgType]
+	stats.Unknown++
+	m.stats[msgType] = stats
+}
+
+// Snapshot returns copy of metrics gathered so far
+func (m *Metrics) Snapshot() map[string]HandlerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]HandlerStats, len(m.stats))
+	for msgType, stats := range m.stats {
+		snapshot[msgType] = stats
+	}
+	return snapshot
+}
+
+// Report logs metrics every interval until context is done
+func (m *Metrics) Report(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			snapshot := m.Snapshot()
+			types := make([]string, 0, len(snapshot))
+			for msgType := range snapshot {
+				types = append(types, msgType)
+			}
+			sort.Strings(types)
+			for _, msgType := range types {
+				stats := snapshot[msgType]
+				log.Printf("handler metrics: %s: count %d, errors %d, rejected %d, unknown %d, total time %s\n",
+					msgType, stats.Count, stats.Errors, stats.Rejected, stats.Unknown, stats.Duration)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
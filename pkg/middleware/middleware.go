@@ -0,0 +1,103 @@
+// Package middleware contains handler middlewares which can be configured at startup
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// Recover turns handler panics into commit errors, so processor can shut down gracefully
+func Recover() processor.Middleware {
+	return func(next processor.HandlerFunc) processor.HandlerFunc {
+		return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("handler of %s panicked: %v\n%s", describe(ctx, metadata, msg), r, debug.Stack())
+					err = fmt.Errorf("%w: handler of %s message panicked: %v", processor.CommitError, msg.Type(), r)
+				}
+			}()
+			return next(ctx, metadata, msg)
+		}
+	}
+}
+
+// Timing logs handlers which took longer than threshold
+func Timing(threshold time.Duration) processor.Middleware {
+	return func(next processor.HandlerFunc) processor.HandlerFunc {
+		return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			start := time.Now()
+			err := next(ctx, metadata, msg)
+			if elapsed := time.Since(start); elapsed > threshold {
+				log.Printf("slow handler: %s took %s\n", describe(ctx, metadata, msg), elapsed)
+			}
+			return err
+		}
+	}
+}
+
+// Sample logs every n-th handled message, it is meant for debugging
+func Sample(n uint64) processor.Middleware {
+	counter := uint64(0)
+	return func(next processor.HandlerFunc) processor.HandlerFunc {
+		return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			if n > 0 && atomic.AddUint64(&counter, 1)%n == 0 {
+				log.Printf("%s: %+v\n", describe(ctx, metadata, msg), msg)
+			}
+			return next(ctx, metadata, msg)
+		}
+	}
+}
+
+// FilterChains calls handlers only for messages of chains accepted by filter,
+// blocks of other chains are still processed, only their messages are skipped
+func FilterChains(filter processor.ChainFilter) processor.Middleware {
+	return func(next processor.HandlerFunc) processor.HandlerFunc {
+		return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			if !filter.Accepts(metadata.ChainID) {
+				return nil
+			}
+			return next(ctx, metadata, msg)
+		}
+	}
+}
+
+type traceKey struct{}
+
+// Trace puts trace ID identifying handled message into the context, so other middlewares can log it,
+// nested messages of tx extend trace ID of their tx
+func Trace() processor.Middleware {
+	return func(next processor.HandlerFunc) processor.HandlerFunc {
+		return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			id, ok := TraceID(ctx)
+			if !ok {
+				id = fmt.Sprintf("%s/%d", metadata.ChainID, metadata.BlockHeight)
+			}
+			if tx, ok := msg.(watcher.Transaction); ok {
+				id += "/" + tx.Hash
+			}
+			id += "/" + msg.Type()
+			return next(context.WithValue(ctx, traceKey{}, id), metadata, msg)
+		}
+	}
+}
+
+// TraceID returns trace ID of the message being handled
+func TraceID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(traceKey{}).(string)
+	return id, ok
+}
+
+// describe identifies message in logs by its trace ID if it's traced
+func describe(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) string {
+	if id, ok := TraceID(ctx); ok {
+		return id
+	}
+	return fmt.Sprintf("%s message from %s at height %d", msg.Type(), metadata.ChainID, metadata.BlockHeight)
+}
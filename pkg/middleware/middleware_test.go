@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	handler := Recover()(func(context.Context, processor.MessageMetadata, watcher.Message) error {
+		panic("bug")
+	})
+
+	err := handler(context.Background(), processor.MessageMetadata{}, watcher.Transfer{})
+	assert.True(t, errors.Is(err, processor.CommitError))
+}
+
+func TestFilterChains(t *testing.T) {
+	called := false
+	handler := FilterChains(processor.ChainFilter{Deny: []string{"*-testnet"}})(func(context.Context, processor.MessageMetadata, watcher.Message) error {
+		called = true
+		return nil
+	})
+
+	assert.NoError(t, handler(context.Background(), processor.MessageMetadata{ChainID: "chain-testnet"}, watcher.Transfer{}))
+	assert.False(t, called)
+	assert.NoError(t, handler(context.Background(), processor.MessageMetadata{ChainID: "chain-1"}, watcher.Transfer{}))
+	assert.True(t, called)
+}
+
+func TestTrace(t *testing.T) {
+	ids := []string{}
+	var handler processor.HandlerFunc
+	handler = Trace()(func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+		id, _ := TraceID(ctx)
+		ids = append(ids, id)
+		if tx, ok := msg.(watcher.Transaction); ok {
+			for _, m := range tx.Messages {
+				if err := handler(ctx, metadata, m); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	tx := watcher.Transaction{Hash: "hash1", Messages: []watcher.Message{watcher.Transfer{}}}
+	assert.NoError(t, handler(context.Background(), processor.MessageMetadata{ChainID: "chain1", BlockHeight: 10}, tx))
+	assert.Equal(t, []string{"chain1/10/hash1/transaction", "chain1/10/hash1/transaction/transfer"}, ids)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	failing := m.Middleware()(func(context.Context, processor.MessageMetadata, watcher.Message) error {
+		return errors.New("error")
+	})
+	succeeding := m.Middleware()(func(context.Context, processor.MessageMetadata, watcher.Message) error {
+		return nil
+	})
+
+	rejecting := m.Middleware()(func(context.Context, processor.MessageMetadata, watcher.Message) error {
+		return processor.StateTransitionError
+	})
+
+	assert.Error(t, failing(context.Background(), processor.MessageMetadata{}, watcher.Transfer{}))
+	assert.Error(t, rejecting(context.Background(), processor.MessageMetadata{}, watcher.OpenChannel{}))
+	assert.NoError(t, succeeding(context.Background(), processor.MessageMetadata{}, watcher.Transfer{}))
+	assert.NoError(t, succeeding(context.Background(), processor.MessageMetadata{}, watcher.CreateClient{}))
+	m.CountUnknown("open_connection")
+
+	snapshot := m.Snapshot()
+	assert.Equal(t, 2, snapshot["transfer"].Count)
+	assert.Equal(t, 1, snapshot["transfer"].Errors)
+	assert.Equal(t, 1, snapshot["create_client"].Count)
+	assert.Equal(t, 0, snapshot["create_client"].Errors)
+	assert.Equal(t, 0, snapshot["open_connection"].Count)
+	assert.Equal(t, 1, snapshot["open_connection"].Unknown)
+	assert.Equal(t, 1, snapshot["open_channel"].Rejected)
+	assert.Equal(t, 0, snapshot["open_channel"].Errors)
+}
+
+func TestMetrics_nested(t *testing.T) {
+	m := NewMetrics()
+	var handler processor.HandlerFunc
+	handler = m.Middleware()(func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+		time.Sleep(10 * time.Millisecond)
+		if tx, ok := msg.(watcher.Transaction); ok {
+			for _, m := range tx.Messages {
+				if err := handler(ctx, metadata, m); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	tx := watcher.Transaction{Messages: []watcher.Message{watcher.Transfer{}, watcher.Transfer{}}}
+	assert.NoError(t, handler(context.Background(), processor.MessageMetadata{}, tx))
+
+	// tx handler time does not include time of its message handlers
+	snapshot := m.Snapshot()
+	assert.Equal(t, 2, snapshot["transfer"].Count)
+	assert.True(t, snapshot["transaction"].Duration < snapshot["transfer"].Duration)
+}
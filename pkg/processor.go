@@ -2,90 +2,130 @@ package processor
 
 import (
 	"context"
-	"log"
-
 	"errors"
+	"sync"
+	"time"
 
 	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
 	processor "github.com/mapofzones/txs-processor/pkg/types"
 )
 
+// DefaultConcurrency is the number of blocks processed at the same time if not configured otherwise
+const DefaultConcurrency = 4
+
 // Processor holds handles for all our connections
-// and holds an interface which defines what has to be done
+// and creates processor instance for each chain, which defines what has to be done
 // on the received block
 type Processor struct {
 	Blocks <-chan watcher.Block
-	processor.Processor
+	// blocks of chains not accepted by filter are dropped
+	Chains processor.ChainFilter
+	// maximum number of blocks processed at the same time,
+	// blocks of the same chain are always processed one by one
+	Concurrency  int
+	NewProcessor processor.Factory
 }
 
 // NewProcessor returns instance of initialized processor and error if something goes wrong
-func NewProcessor(ctx context.Context, blocks <-chan watcher.Block, blockProcessor processor.Processor) *Processor {
+func NewProcessor(ctx context.Context, blocks <-chan watcher.Block, newProcessor processor.Factory) *Processor {
 	return &Processor{
-		Blocks:    blocks,
-		Processor: blockProcessor,
+		Blocks:       blocks,
+		Concurrency:  DefaultConcurrency,
+		NewProcessor: newProcessor,
 	}
 }
 
-// Process consumes and processes transactions from rabbitmq
+// Process consumes transactions from rabbitmq and dispatches them to chain workers,
+// every chain has its worker while its blocks keep coming, idle workers stop and release their processors
 func (p *Processor) Process(ctx context.Context) error {
-	// this map is used to avoid constant spam of invalid height messages if that
-	// error occurs
-	ignoredChains := map[string]bool{}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	slots := make(chan struct{}, concurrency)
+	// first fatal error of any worker
+	errs := make(chan error, 1)
+
+	wg := &sync.WaitGroup{}
+	workers := map[string]*chainWorker{}
+	// let workers finish blocks they have already received
+	stop := func() {
+		for _, w := range workers {
+			w.close()
+		}
+		wg.Wait()
+	}
+	// forget workers which stopped being idle
+	sweep := time.NewTicker(idleWorkerTimeout)
+	defer sweep.Stop()
 
 	// receive from block stream and process
 	for {
 		select {
 		case block, ok := <-p.Blocks:
 			if !ok {
+				stop()
 				return errors.New("block channel is closed")
 			}
 
-			if err := p.ProcessBlock(ctx, block); err != nil {
-				// if we have error in our logic or there is no connection
-				if errors.Is(err, processor.ConnectionError) ||
-					errors.Is(err, processor.CommitError) {
-					return err
-				}
+			if !p.Chains.Accepts(block.ChainID()) {
+				ack(block)
+				continue
+			}
 
-				// queue was fixed, no need to suppress messagess from it anymore
-				if _, ok := ignoredChains[block.ChainID()]; ok && err == nil {
-					delete(ignoredChains, block.ChainID())
-				}
+			if w, ok := workers[block.ChainID()]; ok && w.push(block) {
+				continue
+			}
 
-				// log the error if we are not ignoring this chain
-				if _, ok := ignoredChains[block.ChainID()]; !ok {
-					log.Printf("could not process block from %s: %s\n", block.ChainID(), err)
-				}
+			// chain is new or its worker has stopped being idle
+			blockProcessor, err := p.NewProcessor(ctx)
+			if err != nil {
+				stop()
+				return err
+			}
+			previous := workers[block.ChainID()]
+			w := newChainWorker(block.ChainID(), blockProcessor)
+			w.push(block)
+			workers[block.ChainID()] = w
 
-				// if order of blocks is messed up, ignore it until queue is fixed
-				if errors.Is(err, processor.BlockHeightError) {
-					ignoredChains[block.ChainID()] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// previous worker might still be committing its last blocks
+				if previous != nil {
+					<-previous.done
+				}
+				if err := w.Run(ctx, slots); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					cancel()
+				}
+			}()
+		case <-sweep.C:
+			for chainID, w := range workers {
+				select {
+				case <-w.done:
+					delete(workers, chainID)
+				default:
 				}
 			}
+		case err := <-errs:
+			stop()
+			return err
 		case <-ctx.Done():
-			return nil
-		}
-	}
-}
-
-func (p *Processor) ProcessBlock(ctx context.Context, block watcher.Block) error {
-	err := p.Validate(ctx, block)
-	if err != nil {
-		return err
-	}
-
-	for _, message := range block.Messages() {
-		handler := p.Handler(message)
-		if handler != nil {
-			err := handler(ctx, processor.MessageMetadata{
-				ChainID:   block.ChainID(),
-				BlockTime: block.Time(),
-			}, message)
-			if err != nil {
+			stop()
+			// worker might have failed right before cancellation
+			select {
+			case err := <-errs:
 				return err
+			default:
+				return nil
 			}
 		}
 	}
-
-	return p.Commit(ctx, block)
 }
@@ -0,0 +1,367 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLog records what happened to blocks of all chains
+type testLog struct {
+	mu        sync.Mutex
+	acked     []string
+	committed []string
+	created   int
+	closed    int
+}
+
+func (l *testLog) record(list *[]string, b watcher.Block) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*list = append(*list, fmt.Sprintf("%s/%d", b.ChainID(), b.Height()))
+}
+
+func (l *testLog) get(list *[]string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, *list...)
+}
+
+type testBlock struct {
+	chainID string
+	height  int64
+	log     *testLog
+}
+
+func (b testBlock) Height() int64               { return b.height }
+func (b testBlock) ChainID() string             { return b.chainID }
+func (b testBlock) Time() time.Time             { return time.Time{} }
+func (b testBlock) Messages() []watcher.Message { return nil }
+
+func (b testBlock) Ack() error {
+	b.log.record(&b.log.acked, b)
+	return nil
+}
+
+// testDB is shared by replicas, it keeps owners of chains and heights they committed
+type testDB struct {
+	mu      sync.Mutex
+	owners  map[string]string
+	heights map[string]int64
+}
+
+func newTestDB() *testDB {
+	return &testDB{owners: make(map[string]string), heights: make(map[string]int64)}
+}
+
+// release makes chains of the replica free to be taken over, as if it has stopped
+func (db *testDB) release(replica string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for chainID, owner := range db.owners {
+		if owner == replica {
+			delete(db.owners, chainID)
+		}
+	}
+}
+
+// testProcessor commits blocks in batches of the given size
+type testProcessor struct {
+	log     *testLog
+	batch   int
+	commit  func(watcher.Block) error
+	pending []watcher.Block
+
+	// replica owns chains in db, blocks are not checked without db
+	db      *testDB
+	replica string
+}
+
+func (p *testProcessor) Handler(watcher.Message) func(context.Context, processor.MessageMetadata, watcher.Message) error {
+	return nil
+}
+
+func (p *testProcessor) Validate(_ context.Context, b watcher.Block) error {
+	if p.db == nil {
+		return nil
+	}
+	p.db.mu.Lock()
+	defer p.db.mu.Unlock()
+
+	if owner, ok := p.db.owners[b.ChainID()]; ok && owner != p.replica {
+		return fmt.Errorf("%w: %s", processor.ChainLockedError, b.ChainID())
+	}
+	p.db.owners[b.ChainID()] = p.replica
+	if expected := p.db.heights[b.ChainID()] + int64(len(p.pending)) + 1; b.Height() != expected {
+		return fmt.Errorf("%w: expected %d, got %d", processor.BlockHeightError, expected, b.Height())
+	}
+	return nil
+}
+
+func (p *testProcessor) Commit(ctx context.Context, b watcher.Block) error {
+	if p.commit != nil {
+		if err := p.commit(b); err != nil {
+			p.pending = nil
+			return err
+		}
+	}
+	p.pending = append(p.pending, b)
+	if len(p.pending) >= p.batch {
+		return p.Flush(ctx)
+	}
+	return nil
+}
+
+func (p *testProcessor) Flush(context.Context) error {
+	for _, b := range p.pending {
+		p.log.record(&p.log.committed, b)
+		if p.db != nil {
+			p.db.mu.Lock()
+			p.db.heights[b.ChainID()] = b.Height()
+			p.db.mu.Unlock()
+		}
+	}
+	p.pending = nil
+	return nil
+}
+
+func (p *testProcessor) LastProcessedBlock(_ context.Context, chainID string) (int64, error) {
+	p.db.mu.Lock()
+	defer p.db.mu.Unlock()
+	return p.db.heights[chainID], nil
+}
+
+func (p *testProcessor) Pending() int {
+	return len(p.pending)
+}
+
+func (p *testProcessor) Close(context.Context) error {
+	p.log.mu.Lock()
+	defer p.log.mu.Unlock()
+	p.log.closed++
+	return nil
+}
+
+// startProcessor runs processor which gets blocks from the returned channel
+func startProcessor(ctx context.Context, log *testLog, newProcessor func() *testProcessor) (chan<- watcher.Block, <-chan error) {
+	blocks := make(chan watcher.Block)
+	p := NewProcessor(ctx, blocks, func(context.Context) (processor.Processor, error) {
+		log.mu.Lock()
+		log.created++
+		log.mu.Unlock()
+		return newProcessor(), nil
+	})
+	p.Chains = processor.ChainFilter{Deny: []string{"denied-*"}}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.Process(ctx)
+	}()
+	return blocks, errs
+}
+
+func TestProcessor_Process(t *testing.T) {
+	log := &testLog{}
+	blocks, errs := startProcessor(context.Background(), log, func() *testProcessor {
+		return &testProcessor{log: log, batch: 2}
+	})
+
+	for height := int64(1); height <= 3; height++ {
+		blocks <- testBlock{"chain1", height, log}
+		blocks <- testBlock{"chain2", height, log}
+	}
+	blocks <- testBlock{"denied-1", 1, log}
+	close(blocks)
+
+	assert.EqualError(t, <-errs, "block channel is closed")
+	// blocks of each chain are committed in order, pending ones are committed on shutdown
+	committed := log.get(&log.committed)
+	assert.Equal(t, []string{"chain1/1", "chain1/2", "chain1/3"}, filter(committed, "chain1"))
+	assert.Equal(t, []string{"chain2/1", "chain2/2", "chain2/3"}, filter(committed, "chain2"))
+	assert.ElementsMatch(t, append(committed, "denied-1/1"), log.get(&log.acked))
+	assert.Equal(t, 2, log.created)
+	assert.Equal(t, 2, log.closed)
+}
+
+func TestProcessor_ProcessSlowChain(t *testing.T) {
+	log := &testLog{}
+	release := make(chan struct{})
+	blocks, errs := startProcessor(context.Background(), log, func() *testProcessor {
+		return &testProcessor{log: log, batch: 1, commit: func(b watcher.Block) error {
+			if b.ChainID() == "slow" {
+				<-release
+			}
+			return nil
+		}}
+	})
+
+	// slow chain has more blocks waiting than any fixed queue would hold
+	for height := int64(1); height <= 100; height++ {
+		blocks <- testBlock{"slow", height, log}
+	}
+	for height := int64(1); height <= 3; height++ {
+		blocks <- testBlock{"fast", height, log}
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(log.get(&log.acked)) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"fast/1", "fast/2", "fast/3"}, log.get(&log.acked))
+
+	close(release)
+	close(blocks)
+	assert.EqualError(t, <-errs, "block channel is closed")
+	assert.Len(t, filter(log.get(&log.acked), "slow"), 100)
+}
+
+func TestProcessor_ProcessBatch(t *testing.T) {
+	log := &testLog{}
+	blocks, errs := startProcessor(context.Background(), log, func() *testProcessor {
+		return &testProcessor{log: log, batch: 3}
+	})
+
+	for height := int64(1); height <= 4; height++ {
+		blocks <- testBlock{"chain1", height, log}
+	}
+	assert.Eventually(t, func() bool {
+		return len(log.get(&log.acked)) == 3
+	}, time.Second, time.Millisecond)
+	// the last block waits for the next batch, so it is not acknowledged yet
+	assert.Equal(t, []string{"chain1/1", "chain1/2", "chain1/3"}, log.get(&log.acked))
+
+	close(blocks)
+	assert.EqualError(t, <-errs, "block channel is closed")
+	assert.Equal(t, []string{"chain1/1", "chain1/2", "chain1/3", "chain1/4"}, log.get(&log.acked))
+}
+
+func TestProcessor_ProcessFatalError(t *testing.T) {
+	log := &testLog{}
+	blocks, errs := startProcessor(context.Background(), log, func() *testProcessor {
+		return &testProcessor{log: log, batch: 10, commit: func(b watcher.Block) error {
+			if b.Height() == 3 {
+				return fmt.Errorf("%w: test", processor.CommitError)
+			}
+			return nil
+		}}
+	})
+
+	for height := int64(1); height <= 3; height++ {
+		blocks <- testBlock{"chain1", height, log}
+	}
+
+	err := <-errs
+	assert.True(t, errors.Is(err, processor.CommitError))
+	// pending blocks were lost with the failed commit, so they must be delivered again
+	assert.Empty(t, log.get(&log.committed))
+	assert.Empty(t, log.get(&log.acked))
+	assert.Equal(t, 1, log.closed)
+}
+
+func TestProcessor_ProcessIdleWorker(t *testing.T) {
+	timeout := idleWorkerTimeout
+	idleWorkerTimeout = time.Millisecond
+	defer func() {
+		idleWorkerTimeout = timeout
+	}()
+
+	log := &testLog{}
+	blocks, errs := startProcessor(context.Background(), log, func() *testProcessor {
+		return &testProcessor{log: log, batch: 1}
+	})
+
+	blocks <- testBlock{"chain1", 1, log}
+	assert.Eventually(t, func() bool {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		return log.closed == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// chain gets new worker once its blocks come again
+	blocks <- testBlock{"chain1", 2, log}
+	close(blocks)
+	assert.EqualError(t, <-errs, "block channel is closed")
+	assert.Equal(t, []string{"chain1/1", "chain1/2"}, log.get(&log.acked))
+	assert.Equal(t, 2, log.created)
+	assert.Equal(t, 2, log.closed)
+}
+
+func TestProcessor_ProcessFailover(t *testing.T) {
+	interval := followInterval
+	followInterval = time.Millisecond
+	defer func() {
+		followInterval = interval
+	}()
+
+	db := newTestDB()
+	ownerLog, standbyLog := &testLog{}, &testLog{}
+	owner, ownerErrs := startProcessor(context.Background(), ownerLog, func() *testProcessor {
+		return &testProcessor{log: ownerLog, batch: 1, db: db, replica: "owner"}
+	})
+	standby, standbyErrs := startProcessor(context.Background(), standbyLog, func() *testProcessor {
+		return &testProcessor{log: standbyLog, batch: 1, db: db, replica: "standby"}
+	})
+
+	for height := int64(1); height <= 2; height++ {
+		owner <- testBlock{"chain1", height, ownerLog}
+	}
+	assert.Eventually(t, func() bool {
+		return len(ownerLog.get(&ownerLog.committed)) == 2
+	}, time.Second, time.Millisecond)
+
+	// standby keeps blocks the owner has not committed yet
+	for height := int64(1); height <= 4; height++ {
+		standby <- testBlock{"chain1", height, standbyLog}
+	}
+	assert.Eventually(t, func() bool {
+		return len(standbyLog.get(&standbyLog.acked)) == 2
+	}, 3*time.Second, time.Millisecond)
+	assert.Equal(t, []string{"chain1/1", "chain1/2"}, standbyLog.get(&standbyLog.acked))
+	assert.Empty(t, standbyLog.get(&standbyLog.committed))
+
+	// standby takes the chain over once the owner stops
+	close(owner)
+	assert.EqualError(t, <-ownerErrs, "block channel is closed")
+	db.release("owner")
+
+	assert.Eventually(t, func() bool {
+		return len(standbyLog.get(&standbyLog.committed)) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	close(standby)
+	assert.EqualError(t, <-standbyErrs, "block channel is closed")
+	assert.Equal(t, []string{"chain1/3", "chain1/4"}, standbyLog.get(&standbyLog.committed))
+	assert.Equal(t, []string{"chain1/1", "chain1/2", "chain1/3", "chain1/4"}, standbyLog.get(&standbyLog.acked))
+}
+
+func TestProcessor_ProcessStandbyShutdown(t *testing.T) {
+	db := newTestDB()
+	db.owners["chain1"] = "owner"
+	log := &testLog{}
+	blocks, errs := startProcessor(context.Background(), log, func() *testProcessor {
+		return &testProcessor{log: log, batch: 1, db: db, replica: "standby"}
+	})
+
+	for height := int64(1); height <= 2; height++ {
+		blocks <- testBlock{"chain1", height, log}
+	}
+	close(blocks)
+	assert.EqualError(t, <-errs, "block channel is closed")
+	// kept blocks are delivered again after restart
+	assert.Empty(t, log.get(&log.acked))
+	assert.Empty(t, log.get(&log.committed))
+}
+
+func filter(blocks []string, chainID string) []string {
+	filtered := []string{}
+	for _, b := range blocks {
+		if len(b) > len(chainID) && b[:len(chainID)+1] == chainID+"/" {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
 	codec "github.com/mapofzones/cosmos-watcher/pkg/codec"
@@ -15,29 +16,59 @@ import (
 	"golang.org/x/net/context"
 )
 
-// BlockStream creates individual connection to rabbitmq and returns read-only block channel
-func BlockStream(ctx context.Context, addr, queueName string) (<-chan watcher.Block, error) {
-	msgs, err := connect(ctx, addr, queueName)
+// DefaultPrefetch is the number of blocks which can be received from the queue but not moved to queues of their chains yet
+const DefaultPrefetch = 256
+
+// DefaultChainPrefetch is the number of blocks of one chain which can be received but not acknowledged yet
+const DefaultChainPrefetch = 64
+
+// Block is a block received from the queue, it has to be acknowledged once it is stored,
+// unacknowledged blocks are delivered again after reconnect
+type Block struct {
+	watcher.Block
+	delivery amqp.Delivery
+}
+
+// Ack removes block from the queue
+func (b Block) Ack() error {
+	return b.delivery.Ack(false)
+}
+
+// BlockStream creates individual connection to rabbitmq and returns read-only block channel.
+// Blocks are moved from the queue to queues of their chains named <queue>.<chain id> and consumed from there,
+// so a chain which is slow to process fills only its own queue while blocks of other chains keep coming.
+// Prefetch limits the number of blocks which are received but not moved yet,
+// chainPrefetch limits the number of blocks of each chain which are received but not acknowledged yet.
+// If exchange is not empty, queue is bound to that fanout exchange, so every replica with its own queue gets all blocks
+func BlockStream(ctx context.Context, addr, exchange, queueName string, prefetch, chainPrefetch int) (<-chan watcher.Block, error) {
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+	if chainPrefetch <= 0 {
+		chainPrefetch = DefaultChainPrefetch
+	}
+	r, msgs, err := connect(ctx, addr, exchange, queueName, prefetch, chainPrefetch)
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to rabbitmq, %s", err.Error())
 	}
-	return msgToBlocks(ctx, msgs), nil
+	go r.run(ctx, msgs)
+	return r.blocks, nil
 }
 
-func connect(ctx context.Context, addr, queueName string) (<-chan amqp.Delivery, error) {
+func connect(ctx context.Context, addr, exchange, queueName string, prefetch, chainPrefetch int) (*router, <-chan amqp.Delivery, error) {
 	conn, err := amqp.Dial(addr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// get one message at a time
-	if err := ch.Qos(1, 0, false); err != nil {
-		return nil, err
+	// blocks are acknowledged once they are moved to queues of their chains
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return nil, nil, err
 	}
 
 	q, err := ch.QueueDeclare(
@@ -49,21 +80,49 @@ func connect(ctx context.Context, addr, queueName string) (<-chan amqp.Delivery,
 		nil,       // arguments
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if exchange != "" {
+		err = ch.ExchangeDeclare(
+			exchange, // name
+			"fanout", // kind
+			true,     // durable
+			false,    // delete when unused
+			false,    // internal
+			false,    // no-wait
+			nil,      // arguments
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	msgs, err := ch.Consume(
 		q.Name, // queue
 		"",     // consumer
-		true,   // auto-ack
+		false,  // auto-ack
 		false,  // exclusive
 		false,  // no-local
 		false,  // no-wait
 		nil,    // args
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+
+	// blocks are removed from the queue only once broker has confirmed that queue of their chain holds them
+	publisher, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := publisher.Confirm(false); err != nil {
+		return nil, nil, err
+	}
+
 	// here we monitor our context
 	go func() {
 		<-ctx.Done()
@@ -72,50 +131,179 @@ func connect(ctx context.Context, addr, queueName string) (<-chan amqp.Delivery,
 		ch.Close()
 		conn.Close()
 	}()
-	return msgs, nil
-}
 
-// msgToBlocks processes raw messages and transforms them blocks for further processing
-func msgToBlocks(ctx context.Context, msgs <-chan amqp.Delivery) <-chan watcher.Block {
-	blocks := make(chan watcher.Block)
 	cdc := amino.NewCodec()
 	codec.RegisterTypes(cdc)
+	return &router{
+		conn:          conn,
+		publisher:     publisher,
+		confirms:      publisher.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		cdc:           cdc,
+		queueName:     queueName,
+		chainPrefetch: chainPrefetch,
+		chains:        make(map[string]bool),
+		blocks:        make(chan watcher.Block),
+		stop:          make(chan struct{}),
+	}, msgs, nil
+}
+
+// router moves blocks to queues of their chains and merges blocks consumed from them into one channel
+type router struct {
+	conn          *amqp.Connection
+	publisher     *amqp.Channel
+	confirms      chan amqp.Confirmation
+	cdc           *amino.Codec
+	queueName     string
+	chainPrefetch int
+	// chains whose queues are consumed
+	chains map[string]bool
 
+	blocks chan watcher.Block
+	// closed once router stops, so chain consumers stop as well
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// run routes messages until context is done, main queue is closed or interrupt signal is caught,
+// block channel is closed once all chain consumers have stopped
+func (r *router) run(ctx context.Context, msgs <-chan amqp.Delivery) {
 	// Interrupt signal capture for gracefull shutdown
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt)
 
+	defer func() {
+		close(r.stop)
+		r.wg.Wait()
+		close(r.blocks)
+	}()
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			if err := r.route(msg); err != nil {
+				log.Println(err)
+				return
+			}
+		case <-ctx.Done():
+			return
+
+		// send last block and shutdown
+		// processor will process the block and upon observing
+		// that the channel has closed will exit without losing any data
+		case <-sigc:
+			log.Println("interrupt signal caught, shutting down")
+			return
+		}
+	}
+}
+
+// route moves message to the queue of its chain
+func (r *router) route(msg amqp.Delivery) error {
+	var block watcher.Block
+	err := r.cdc.UnmarshalJSON(msg.Body, &block)
+	// if we received invalid block, we can just skip it because history plugin will fetch the blocks anyway
+	if err != nil {
+		_ = msg.Reject(false)
+		return err
+	}
+
+	// queue of the chain must exist before anything is published to it
+	if !r.chains[block.ChainID()] {
+		if err := r.consumeChain(block.ChainID()); err != nil {
+			return fmt.Errorf("could not consume blocks of %s: %w", block.ChainID(), err)
+		}
+		r.chains[block.ChainID()] = true
+	}
+
+	err = r.publisher.Publish(
+		"",                            // exchange
+		r.chainQueue(block.ChainID()), // routing key
+		false,                         // mandatory
+		false,                         // immediate
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Body:         msg.Body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("could not move block %d of %s: %w", block.Height(), block.ChainID(), err)
+	}
+	if confirm, ok := <-r.confirms; !ok || !confirm.Ack {
+		return fmt.Errorf("block %d of %s was not confirmed by rabbitmq", block.Height(), block.ChainID())
+	}
+	return msg.Ack(false)
+}
+
+// chainQueue returns name of the queue which holds blocks of the chain
+func (r *router) chainQueue(chainID string) string {
+	return r.queueName + "." + chainID
+}
+
+// consumeChain declares queue of the chain and passes its blocks to the block channel,
+// blocks left in the queue by previous run are delivered first
+func (r *router) consumeChain(chainID string) error {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return err
+	}
+	// chain can't hold more blocks than that, even if it's too slow to process them
+	if err := ch.Qos(r.chainPrefetch, 0, false); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		r.chainQueue(chainID), // name
+		true,                  // durable
+		false,                 // delete when unused
+		false,                 // exclusive
+		false,                 // no-wait
+		nil,                   // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		q.Name, // queue
+		"",     // consumer
+		false,  // auto-ack
+		false,  // exclusive
+		false,  // no-local
+		false,  // no-wait
+		nil,    // args
+	)
+	if err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
 	go func() {
-		defer close(blocks)
+		defer r.wg.Done()
 		for {
 			select {
 			case msg, ok := <-msgs:
-				var block watcher.Block
 				if !ok {
 					return
 				}
-				err := cdc.UnmarshalJSON(msg.Body, &block)
-				// if we received invalid block, we can just skip it because history plugin will fetch the blocks anyway
-				if err != nil {
+				var block watcher.Block
+				// router has decoded the same message already, so this should not happen
+				if err := r.cdc.UnmarshalJSON(msg.Body, &block); err != nil {
 					log.Println(err)
-					return
+					_ = msg.Reject(false)
+					continue
 				}
 				select {
-				case blocks <- block:
-				case <-ctx.Done():
+				case r.blocks <- Block{Block: block, delivery: msg}:
+				case <-r.stop:
 					return
 				}
-			case <-ctx.Done():
-				return
-
-			// send last block and shutdown
-			// processor will process the block and upon observing
-			// that the channel has closed will exit without losing any data
-			case <-sigc:
-				log.Println("interrupt signal caught, shutting down")
+			case <-r.stop:
 				return
 			}
 		}
 	}()
-	return blocks
+	return nil
 }
@@ -0,0 +1,32 @@
+package processor
+
+import "strings"
+
+// Application is the kind of ibc application bound to the channel port
+type Application string
+
+const (
+	ApplicationTransfer           Application = "transfer"
+	ApplicationInterchainAccounts Application = "interchain_accounts"
+	ApplicationInterchainQueries  Application = "interchain_queries"
+	ApplicationCosmWasm           Application = "cosmwasm"
+	ApplicationCustom             Application = "custom"
+)
+
+// ApplicationFromPort classifies channel by the port it is bound to
+// https://github.com/cosmos/ibc/tree/master/spec/app
+func ApplicationFromPort(portID string) Application {
+	switch {
+	case portID == "transfer":
+		return ApplicationTransfer
+	case portID == "icahost" || strings.HasPrefix(portID, "icacontroller-"):
+		return ApplicationInterchainAccounts
+	case portID == "icqhost" || portID == "interchainquery":
+		return ApplicationInterchainQueries
+	// cosmwasm contracts bind ports named after contract address
+	case strings.HasPrefix(portID, "wasm."):
+		return ApplicationCosmWasm
+	default:
+		return ApplicationCustom
+	}
+}
@@ -0,0 +1,29 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplicationFromPort(t *testing.T) {
+	tests := []struct {
+		name     string
+		portID   string
+		expected Application
+	}{
+		{"transfer", "transfer", ApplicationTransfer},
+		{"ica_host", "icahost", ApplicationInterchainAccounts},
+		{"ica_controller", "icacontroller-cosmos1owner", ApplicationInterchainAccounts},
+		{"icq_host", "icqhost", ApplicationInterchainQueries},
+		{"icq_legacy", "interchainquery", ApplicationInterchainQueries},
+		{"wasm_contract", "wasm.juno1contract", ApplicationCosmWasm},
+		{"custom", "oracle", ApplicationCustom},
+		{"empty", "", ApplicationCustom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ApplicationFromPort(tt.portID))
+		})
+	}
+}
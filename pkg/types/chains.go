@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ChainFilter accepts or drops chains by their IDs or glob patterns, e.g. "*-testnet-*"
+type ChainFilter struct {
+	// if not empty, only matching chains are accepted
+	Allow []string
+	// matching chains are always dropped
+	Deny []string
+}
+
+// NewChainFilter parses comma separated lists of allowed and denied chain patterns
+func NewChainFilter(allow, deny string) (ChainFilter, error) {
+	allowed, err := ParseChainPatterns(allow)
+	if err != nil {
+		return ChainFilter{}, err
+	}
+	denied, err := ParseChainPatterns(deny)
+	if err != nil {
+		return ChainFilter{}, err
+	}
+	return ChainFilter{Allow: allowed, Deny: denied}, nil
+}
+
+// ParseChainPatterns parses comma separated list of chain IDs or glob patterns
+func ParseChainPatterns(s string) ([]string, error) {
+	patterns := splitList(s)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid chain pattern %q: %w", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+// Accepts tells if blocks of the chain must be processed
+func (f ChainFilter) Accepts(chainID string) bool {
+	if MatchChain(f.Deny, chainID) {
+		return false
+	}
+	return len(f.Allow) == 0 || MatchChain(f.Allow, chainID)
+}
+
+// MatchChain tells if chain ID matches any of the patterns
+func MatchChain(patterns []string, chainID string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, chainID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DisabledHandler identifies handler which must not be called for chains matching the pattern
+type DisabledHandler struct {
+	Chain   string
+	MsgType string
+	Name    string
+}
+
+// ParseDisabledHandlers parses comma separated list of <chain pattern>:<message type>/<handler name>,
+// e.g. "*-testnet:transaction/addresses"
+func ParseDisabledHandlers(s string) ([]DisabledHandler, error) {
+	handlers := []DisabledHandler{}
+	for _, item := range splitList(s) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid disabled handler %q: expected <chain pattern>:<message type>/<handler name>", item)
+		}
+		handler := strings.SplitN(parts[1], "/", 2)
+		if len(handler) != 2 || handler[0] == "" || handler[1] == "" {
+			return nil, fmt.Errorf("invalid disabled handler %q: expected <chain pattern>:<message type>/<handler name>", item)
+		}
+		if _, err := path.Match(parts[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid chain pattern %q: %w", parts[0], err)
+		}
+		handlers = append(handlers, DisabledHandler{Chain: parts[0], MsgType: handler[0], Name: handler[1]})
+	}
+	return handlers, nil
+}
+
+// ParseStartHeights parses comma separated list of "<chain id>:<height>" items,
+// height is the first block of the chain which has to be processed
+func ParseStartHeights(s string) (map[string]int64, error) {
+	heights := map[string]int64{}
+	for _, item := range splitList(s) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid start height %q: expected <chain id>:<height>", item)
+		}
+		height, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || height < 1 {
+			return nil, fmt.Errorf("invalid start height %q: height must be a positive number", item)
+		}
+		heights[parts[0]] = height
+	}
+	return heights, nil
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainFilter_Accepts(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    string
+		deny     string
+		chainID  string
+		expected bool
+	}{
+		{"empty_filter", "", "", "cosmoshub-4", true},
+		{"allowed_by_id", "cosmoshub-4, osmosis-1", "", "osmosis-1", true},
+		{"not_allowed", "cosmoshub-4,osmosis-1", "", "juno-1", false},
+		{"allowed_by_pattern", "cosmoshub-*", "", "cosmoshub-4", true},
+		{"denied_by_pattern", "", "*-testnet*", "theta-testnet-001", false},
+		{"deny_wins", "*", "juno-1", "juno-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewChainFilter(tt.allow, tt.deny)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, f.Accepts(tt.chainID))
+		})
+	}
+
+	_, err := NewChainFilter("[", "")
+	assert.Error(t, err)
+}
+
+func TestParseChainPatterns(t *testing.T) {
+	patterns, err := ParseChainPatterns(" cosmoshub-*, osmosis-1,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cosmoshub-*", "osmosis-1"}, patterns)
+
+	_, err = ParseChainPatterns("osmosis-1,[")
+	assert.Error(t, err)
+}
+
+func TestParseDisabledHandlers(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		expected []DisabledHandler
+		err      bool
+	}{
+		{"empty", "", []DisabledHandler{}, false},
+		{
+			"many_handlers",
+			"*-testnet*:transaction/addresses, juno-1:ibc_transfer/ibc_transfer",
+			[]DisabledHandler{{"*-testnet*", "transaction", "addresses"}, {"juno-1", "ibc_transfer", "ibc_transfer"}},
+			false,
+		},
+		{"missing_chain", "transaction/addresses", nil, true},
+		{"missing_name", "juno-1:transaction", nil, true},
+		{"invalid_pattern", "[:transaction/addresses", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := ParseDisabledHandlers(tt.s)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestParseStartHeights(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		expected map[string]int64
+		err      bool
+	}{
+		{"empty", "", map[string]int64{}, false},
+		{"many_chains", "cosmoshub-4:5200791, osmosis-1:1", map[string]int64{"cosmoshub-4": 5200791, "osmosis-1": 1}, false},
+		{"missing_height", "cosmoshub-4", nil, true},
+		{"missing_chain", ":100", nil, true},
+		{"invalid_height", "cosmoshub-4:abc", nil, true},
+		{"zero_height", "cosmoshub-4:0", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := ParseStartHeights(tt.s)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
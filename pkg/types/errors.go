@@ -7,3 +7,7 @@ var CommitError = errors.New("could not process block")
 var ConnectionError = errors.New("could not connect")
 
 var BlockHeightError = errors.New("received block at invalid height")
+
+var StateTransitionError = errors.New("invalid state transition")
+
+var ChainLockedError = errors.New("chain is processed by another instance")
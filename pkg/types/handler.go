@@ -15,8 +15,9 @@ type Handler interface {
 
 // MessageMetada is info which might be needed inside handler function
 type MessageMetadata struct {
-	ChainID   string
-	BlockTime time.Time
+	ChainID     string
+	BlockHeight int64
+	BlockTime   time.Time
 	// if this pointer is not nil, then message has happened inside tx
 	*TxMetadata
 }
@@ -15,3 +15,40 @@ type Processor interface {
 	// commit is used to transact all state changes if that is necessary
 	Commit(context.Context, watcher.Block) error
 }
+
+// Prefetcher can be optionally implemented by processor
+// in order to load data needed by handlers in bulk before block messages are handled
+type Prefetcher interface {
+	Prefetch(context.Context, watcher.Block) error
+}
+
+// Factory creates processor instance with its own state,
+// every chain is processed by a separate instance
+type Factory func(context.Context) (Processor, error)
+
+// Flusher is implemented by processors which may delay commit of processed blocks,
+// Flush commits everything that is still pending
+type Flusher interface {
+	Flush(context.Context) error
+	// Pending returns number of processed blocks which are not committed yet
+	Pending() int
+}
+
+// Closer is implemented by processors which hold resources,
+// Close is called once processor is not going to receive blocks anymore
+type Closer interface {
+	Close(context.Context) error
+}
+
+// Acknowledger is implemented by blocks which have to be confirmed to their source,
+// source delivers blocks which were not confirmed again
+type Acknowledger interface {
+	// Ack confirms that block is stored or must be dropped
+	Ack() error
+}
+
+// Follower is implemented by processors which can tell how far the chain was committed by any instance,
+// blocks of a chain owned by another instance are acknowledged once the owner has committed them
+type Follower interface {
+	LastProcessedBlock(ctx context.Context, chainID string) (int64, error)
+}
@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+)
+
+// compile time check
+var _ Handler = &Registry{}
+
+// HandlerFunc handles single message
+type HandlerFunc func(context.Context, MessageMetadata, watcher.Message) error
+
+// Middleware wraps handler in order to add cross-cutting behaviour to it
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Registration is a handler registered for the message type
+type Registration struct {
+	Name string
+	// handlers with higher priority are called first
+	Priority int
+	Enabled  bool
+	// patterns of chains for which handler is not called
+	DisabledChains []string
+	Handle         HandlerFunc
+}
+
+// Registry dispatches messages to handlers registered for their type,
+// messages of types without handlers are counted as unknown
+type Registry struct {
+	mu          sync.Mutex
+	handlers    map[string][]*Registration
+	middlewares []Middleware
+	unknown     map[string]int
+	onUnknown   []func(msgType string)
+}
+
+// NewRegistry returns empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string][]*Registration),
+		unknown:  make(map[string]int),
+	}
+}
+
+// Register adds enabled handler for messages of given type,
+// name must be unique among handlers of the same type
+func (r *Registry) Register(msgType, name string, priority int, handle HandlerFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reg := range r.handlers[msgType] {
+		if reg.Name == name {
+			return fmt.Errorf("handler %s is already registered for %s messages", name, msgType)
+		}
+	}
+
+	r.handlers[msgType] = append(r.handlers[msgType], &Registration{
+		Name:     name,
+		Priority: priority,
+		Enabled:  true,
+		Handle:   handle,
+	})
+	// keep registration order for handlers with the same priority
+	sort.SliceStable(r.handlers[msgType], func(i, j int) bool {
+		return r.handlers[msgType][i].Priority > r.handlers[msgType][j].Priority
+	})
+	return nil
+}
+
+// Use adds middlewares wrapping handlers of every message type,
+// the first middleware is the outermost one
+func (r *Registry) Use(middlewares ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// OnUnknown adds hook called for every message of type without handlers
+func (r *Registry) OnUnknown(hook func(msgType string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onUnknown = append(r.onUnknown, hook)
+}
+
+// SetEnabled enables or disables handler registered for messages of given type
+func (r *Registry) SetEnabled(msgType, name string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reg := range r.handlers[msgType] {
+		if reg.Name == name {
+			reg.Enabled = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("handler %s is not registered for %s messages", name, msgType)
+}
+
+// DisableForChains disables handler registered for messages of given type for chains matching the patterns
+func (r *Registry) DisableForChains(msgType, name string, patterns ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reg := range r.handlers[msgType] {
+		if reg.Name == name {
+			reg.DisabledChains = append(reg.DisabledChains, patterns...)
+			return nil
+		}
+	}
+	return fmt.Errorf("handler %s is not registered for %s messages", name, msgType)
+}
+
+// Handler returns function calling all enabled handlers of the message type in order of priority,
+// nil is returned if there is nothing to call. StateTransitionError only rejects the message
+// and is not returned, so it does not fail the block
+func (r *Registry) Handler(msg watcher.Message) func(context.Context, MessageMetadata, watcher.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	regs, ok := r.handlers[msg.Type()]
+	if !ok {
+		if r.unknown[msg.Type()] == 0 {
+			log.Printf("no handlers registered for %s messages\n", msg.Type())
+		}
+		r.unknown[msg.Type()]++
+		for _, hook := range r.onUnknown {
+			hook(msg.Type())
+		}
+		return nil
+	}
+
+	handlers := make([]Registration, 0, len(regs))
+	for _, reg := range regs {
+		if reg.Enabled {
+			handlers = append(handlers, *reg)
+		}
+	}
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	var handler HandlerFunc = func(ctx context.Context, metadata MessageMetadata, msg watcher.Message) error {
+		for _, reg := range handlers {
+			if MatchChain(reg.DisabledChains, metadata.ChainID) {
+				continue
+			}
+			if err := reg.Handle(ctx, metadata, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+	return func(ctx context.Context, metadata MessageMetadata, msg watcher.Message) error {
+		err := handler(ctx, metadata, msg)
+		// message which can't change the state is rejected, middlewares have seen the error,
+		// but the rest of the block is still valid
+		if errors.Is(err, StateTransitionError) {
+			log.Printf("%s message rejected: %s\n", msg.Type(), err)
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	calls := []string{}
+	handler := func(name string) HandlerFunc {
+		return func(context.Context, MessageMetadata, watcher.Message) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	r := NewRegistry()
+	assert.NoError(t, r.Register("transfer", "low", -1, handler("low")))
+	assert.NoError(t, r.Register("transfer", "first", 0, handler("first")))
+	assert.NoError(t, r.Register("transfer", "high", 10, handler("high")))
+	assert.NoError(t, r.Register("transfer", "second", 0, handler("second")))
+	assert.Error(t, r.Register("transfer", "second", 0, handler("second")))
+
+	msg := watcher.Transfer{}
+	assert.NoError(t, r.Handler(msg)(context.Background(), MessageMetadata{}, msg))
+	assert.Equal(t, []string{"high", "first", "second", "low"}, calls)
+
+	calls = nil
+	assert.NoError(t, r.SetEnabled("transfer", "high", false))
+	assert.Error(t, r.SetEnabled("transfer", "missing", false))
+	assert.NoError(t, r.Handler(msg)(context.Background(), MessageMetadata{}, msg))
+	assert.Equal(t, []string{"first", "second", "low"}, calls)
+}
+
+func TestRegistry_HandlerStopsOnError(t *testing.T) {
+	expected := errors.New("handler error")
+	called := false
+
+	r := NewRegistry()
+	assert.NoError(t, r.Register("transfer", "failing", 1, func(context.Context, MessageMetadata, watcher.Message) error {
+		return expected
+	}))
+	assert.NoError(t, r.Register("transfer", "next", 0, func(context.Context, MessageMetadata, watcher.Message) error {
+		called = true
+		return nil
+	}))
+
+	msg := watcher.Transfer{}
+	assert.Equal(t, expected, r.Handler(msg)(context.Background(), MessageMetadata{}, msg))
+	assert.False(t, called)
+}
+
+func TestRegistry_HandlerRejects(t *testing.T) {
+	seen := []error{}
+	r := NewRegistry()
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, metadata MessageMetadata, msg watcher.Message) error {
+			err := next(ctx, metadata, msg)
+			seen = append(seen, err)
+			return err
+		}
+	})
+	assert.NoError(t, r.Register("open_channel", "rejecting", 0, func(context.Context, MessageMetadata, watcher.Message) error {
+		return fmt.Errorf("%w: channel-0", StateTransitionError)
+	}))
+
+	// invalid transition does not fail the block, but middlewares see it
+	msg := watcher.OpenChannel{}
+	assert.NoError(t, r.Handler(msg)(context.Background(), MessageMetadata{}, msg))
+	assert.Len(t, seen, 1)
+	assert.True(t, errors.Is(seen[0], StateTransitionError))
+}
+
+func TestRegistry_Unknown(t *testing.T) {
+	r := NewRegistry()
+	unknown := map[string]int{}
+	r.OnUnknown(func(msgType string) {
+		unknown[msgType]++
+	})
+	assert.NoError(t, r.Register("transfer", "disabled", 0, func(context.Context, MessageMetadata, watcher.Message) error {
+		return nil
+	}))
+	assert.NoError(t, r.SetEnabled("transfer", "disabled", false))
+
+	// disabled handlers are known, they just do nothing
+	assert.Nil(t, r.Handler(watcher.Transfer{}))
+	assert.Nil(t, r.Handler(watcher.CreateClient{}))
+	assert.Nil(t, r.Handler(watcher.CreateClient{}))
+
+	assert.Equal(t, map[string]int{"create_client": 2}, unknown)
+}
+
+func TestRegistry_Use(t *testing.T) {
+	calls := []string{}
+	middleware := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, metadata MessageMetadata, msg watcher.Message) error {
+				calls = append(calls, name)
+				return next(ctx, metadata, msg)
+			}
+		}
+	}
+
+	r := NewRegistry()
+	assert.NoError(t, r.Register("transfer", "handler", 0, func(context.Context, MessageMetadata, watcher.Message) error {
+		calls = append(calls, "handler")
+		return nil
+	}))
+	r.Use(middleware("outer"), middleware("inner"))
+
+	msg := watcher.Transfer{}
+	assert.NoError(t, r.Handler(msg)(context.Background(), MessageMetadata{}, msg))
+	assert.Equal(t, []string{"outer", "inner", "handler"}, calls)
+}
+
+func TestRegistry_DisableForChains(t *testing.T) {
+	calls := []string{}
+	r := NewRegistry()
+	assert.NoError(t, r.Register("transaction", "addresses", 0, func(_ context.Context, metadata MessageMetadata, _ watcher.Message) error {
+		calls = append(calls, metadata.ChainID)
+		return nil
+	}))
+	assert.NoError(t, r.DisableForChains("transaction", "addresses", "*-testnet"))
+	assert.Error(t, r.DisableForChains("transaction", "missing", "*-testnet"))
+
+	msg := watcher.Transaction{}
+	assert.NoError(t, r.Handler(msg)(context.Background(), MessageMetadata{ChainID: "spammy-testnet"}, msg))
+	assert.NoError(t, r.Handler(msg)(context.Background(), MessageMetadata{ChainID: "cosmoshub-4"}, msg))
+	assert.Equal(t, []string{"cosmoshub-4"}, calls)
+}
@@ -0,0 +1,64 @@
+package processor
+
+import "time"
+
+// ChannelState represents stage of the ibc channel handshake
+// https://github.com/cosmos/ics/tree/master/spec/ics-004-channel-and-packet-semantics
+type ChannelState string
+
+const (
+	ChannelInit    ChannelState = "INIT"
+	ChannelTryOpen ChannelState = "TRYOPEN"
+	ChannelOpen    ChannelState = "OPEN"
+	ChannelClosed  ChannelState = "CLOSED"
+)
+
+// CanTransitionTo tells if channel in the current state can move to the next one
+func (s ChannelState) CanTransitionTo(next ChannelState) bool {
+	switch next {
+	case ChannelOpen:
+		return s == ChannelInit || s == ChannelTryOpen
+	case ChannelClosed:
+		return s == ChannelInit || s == ChannelTryOpen || s == ChannelOpen
+	default:
+		// INIT and TRYOPEN are only reachable by creating new channel
+		return false
+	}
+}
+
+// ChannelEvent is a record of channel moving to the new state
+type ChannelEvent struct {
+	ChannelID string
+	State     ChannelState
+	Height    int64
+	Time      time.Time
+	// empty if event happened outside of tx
+	TxHash string
+}
+
+// ChannelUptime returns how long channel has been open until given moment
+// events must belong to the same channel and be ordered by height
+func ChannelUptime(events []ChannelEvent, until time.Time) time.Duration {
+	uptime := time.Duration(0)
+	var openedAt *time.Time
+	for i := range events {
+		if events[i].Time.After(until) {
+			break
+		}
+		switch events[i].State {
+		case ChannelOpen:
+			if openedAt == nil {
+				openedAt = &events[i].Time
+			}
+		default:
+			if openedAt != nil {
+				uptime += events[i].Time.Sub(*openedAt)
+				openedAt = nil
+			}
+		}
+	}
+	if openedAt != nil {
+		uptime += until.Sub(*openedAt)
+	}
+	return uptime
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelState_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     ChannelState
+		to       ChannelState
+		expected bool
+	}{
+		{"init_to_open", ChannelInit, ChannelOpen, true},
+		{"tryopen_to_open", ChannelTryOpen, ChannelOpen, true},
+		{"init_to_closed", ChannelInit, ChannelClosed, true},
+		{"open_to_closed", ChannelOpen, ChannelClosed, true},
+		{"open_to_open", ChannelOpen, ChannelOpen, false},
+		{"closed_to_open", ChannelClosed, ChannelOpen, false},
+		{"closed_to_closed", ChannelClosed, ChannelClosed, false},
+		{"open_to_init", ChannelOpen, ChannelInit, false},
+		{"unknown_to_open", "", ChannelOpen, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.from.CanTransitionTo(tt.to))
+		})
+	}
+}
+
+func TestChannelUptime(t *testing.T) {
+	start, _ := time.Parse("2006-01-02T15:04:05", "2006-01-02T15:00:00")
+	at := func(hours int) time.Time {
+		return start.Add(time.Duration(hours) * time.Hour)
+	}
+	tests := []struct {
+		name     string
+		events   []ChannelEvent
+		until    time.Time
+		expected time.Duration
+	}{
+		{"no_events", nil, at(10), 0},
+		{
+			"never_opened",
+			[]ChannelEvent{{State: ChannelInit, Time: at(0)}},
+			at(10),
+			0,
+		},
+		{
+			"still_open",
+			[]ChannelEvent{{State: ChannelInit, Time: at(0)}, {State: ChannelOpen, Time: at(1)}},
+			at(10),
+			9 * time.Hour,
+		},
+		{
+			"closed",
+			[]ChannelEvent{{State: ChannelInit, Time: at(0)}, {State: ChannelOpen, Time: at(1)}, {State: ChannelClosed, Time: at(4)}},
+			at(10),
+			3 * time.Hour,
+		},
+		{
+			"closed_after_until",
+			[]ChannelEvent{{State: ChannelOpen, Time: at(1)}, {State: ChannelClosed, Time: at(4)}},
+			at(2),
+			time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ChannelUptime(tt.events, tt.until))
+		})
+	}
+}
@@ -2,6 +2,7 @@ package processor
 
 import (
 	"math/big"
+	"sort"
 	"time"
 )
 
@@ -9,11 +10,94 @@ import (
 type TxStats struct {
 	ChainID					string
 	Hour					time.Time //must have 0 minutes, seconds and micro/nano seconds
-	Count					int
+	Count					int // all txs, including failed ones
+	TxFailed				int
 	TxWithIBCTransfer		int
 	TxWithIBCTransferFail	int
-	Addresses				[]string
+	Senders					AddressSet
+	Receivers				AddressSet
 	TurnoverAmount			*big.Int
+	FailedIBCTransferAmount	*big.Int
+	FailedMessages			map[string]int // number of messages of each type inside failed txs
+}
+
+// NewTxStats returns empty tx stats of the given hour
+func NewTxStats(chainID string, t time.Time) *TxStats {
+	return &TxStats{
+		ChainID:                 chainID,
+		Hour:                    t.Truncate(time.Hour),
+		Senders:                 make(AddressSet),
+		Receivers:               make(AddressSet),
+		TurnoverAmount:          big.NewInt(0),
+		FailedIBCTransferAmount: big.NewInt(0),
+		FailedMessages:          make(map[string]int),
+	}
+}
+
+// AddressSet is a deduplicated set of addresses
+type AddressSet map[string]struct{}
+
+// Add puts address into the set, empty addresses are ignored
+func (s AddressSet) Add(address string) {
+	if address != "" {
+		s[address] = struct{}{}
+	}
+}
+
+// Has tells if address is in the set
+func (s AddressSet) Has(address string) bool {
+	_, ok := s[address]
+	return ok
+}
+
+// Slice returns addresses sorted alphabetically
+func (s AddressSet) Slice() []string {
+	addresses := make([]string, 0, len(s))
+	for address := range s {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// Addresses returns all active addresses, both senders and receivers
+func (s *TxStats) Addresses() AddressSet {
+	addresses := make(AddressSet, len(s.Senders)+len(s.Receivers))
+	for address := range s.Senders {
+		addresses.Add(address)
+	}
+	for address := range s.Receivers {
+		addresses.Add(address)
+	}
+	return addresses
+}
+
+// Period is a time span for which active addresses are counted
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// Periods lists all periods for which we count active addresses
+var Periods = []Period{PeriodDay, PeriodWeek, PeriodMonth}
+
+// Start returns beginning of the period which contains given moment,
+// weeks start on monday
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch p {
+	case PeriodWeek:
+		// sunday is 0, but it's the last day of the week
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
 }
 
 // IbcStats represents statistics that we need to write to db
@@ -62,3 +146,33 @@ func (m IbcData) ToIbcStats() []IbcStats {
 	}
 	return stats
 }
+
+// IbcAppStats represents ibc statistics of one application
+type IbcAppStats struct {
+	Application Application
+	IbcStats
+}
+
+// IbcAppData is used to organize ibc tx data during each hour per application
+type IbcAppData map[Application]IbcData
+
+// Append puts data into ibc data structure of the given application
+func (m *IbcAppData) Append(app Application, source, destination string, t time.Time) {
+	if *m == nil {
+		*m = make(IbcAppData)
+	}
+	data := (*m)[app]
+	data.Append(source, destination, t)
+	(*m)[app] = data
+}
+
+// ToIbcAppStats returns slice of ibc stats of every application
+func (m IbcAppData) ToIbcAppStats() []IbcAppStats {
+	stats := []IbcAppStats{}
+	for app, data := range m {
+		for _, s := range data.ToIbcStats() {
+			stats = append(stats, IbcAppStats{Application: app, IbcStats: s})
+		}
+	}
+	return stats
+}
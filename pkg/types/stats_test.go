@@ -97,3 +97,60 @@ func TestIbcData_ToIbcStats(t *testing.T) {
         })
     }
 }
+
+func TestIbcAppData(t *testing.T) {
+	timeArgs, _ := time.Parse("2006-01-02T15:04:05", "2006-01-02T15:04:05")
+	timeWant, _ := time.Parse("2006-01-02T15:00:00", "2006-01-02T15:00:00")
+
+	m := IbcAppData{}
+	m.Append(ApplicationTransfer, "source", "destination", timeArgs)
+	m.Append(ApplicationTransfer, "source", "destination", timeArgs)
+	m.Append(ApplicationCosmWasm, "source", "destination", timeArgs)
+
+	assert.ElementsMatch(t, []IbcAppStats{
+		{ApplicationTransfer, IbcStats{"source", "destination", timeWant, 2}},
+		{ApplicationCosmWasm, IbcStats{"source", "destination", timeWant, 1}},
+	}, m.ToIbcAppStats())
+}
+
+func TestTxStats_Addresses(t *testing.T) {
+	stats := NewTxStats("chain", time.Now())
+	stats.Senders.Add("address1")
+	stats.Senders.Add("address1")
+	stats.Senders.Add("")
+	stats.Receivers.Add("address1")
+	stats.Receivers.Add("address2")
+
+	assert.Equal(t, AddressSet{"address1": {}}, stats.Senders)
+	assert.Equal(t, AddressSet{"address1": {}, "address2": {}}, stats.Receivers)
+	assert.Equal(t, AddressSet{"address1": {}, "address2": {}}, stats.Addresses())
+	assert.True(t, stats.Senders.Has("address1"))
+	assert.False(t, stats.Senders.Has("address2"))
+}
+
+func TestPeriod_Start(t *testing.T) {
+	// wednesday
+	moment, _ := time.Parse("2006-01-02T15:04:05", "2020-07-15T13:45:10")
+	// sunday
+	sunday, _ := time.Parse("2006-01-02T15:04:05", "2020-07-19T23:59:59")
+	parse := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02", s)
+		return t
+	}
+	tests := []struct {
+		name     string
+		period   Period
+		t        time.Time
+		expected time.Time
+	}{
+		{"day", PeriodDay, moment, parse("2020-07-15")},
+		{"week", PeriodWeek, moment, parse("2020-07-13")},
+		{"week_on_sunday", PeriodWeek, sunday, parse("2020-07-13")},
+		{"month", PeriodMonth, moment, parse("2020-07-01")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.period.Start(tt.t))
+		})
+	}
+}
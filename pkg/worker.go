@@ -0,0 +1,329 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// idleFlushInterval is how long worker waits for the next block before committing pending ones
+const idleFlushInterval = time.Second
+
+// idleWorkerTimeout is how long worker waits for the next block before it stops and releases its processor
+var idleWorkerTimeout = time.Minute
+
+// followInterval is how often worker of a chain owned by another instance checks how far the owner got
+var followInterval = time.Second
+
+// chainWorker processes blocks of a single chain in order,
+// it has its own processor instance, so its state is not shared with other chains
+type chainWorker struct {
+	chainID string
+	processor.Processor
+
+	// blocks waiting to be processed, queue is not limited, so dispatcher never waits for a slow chain,
+	// the number of blocks of one chain is limited by the block source instead
+	mu     sync.Mutex
+	queue  []watcher.Block
+	closed bool
+	wake   chan struct{}
+	// closed once worker has stopped and released its processor
+	done chan struct{}
+
+	// processed blocks which are not acknowledged yet, they are acknowledged once processor committed them
+	unacked []watcher.Block
+	// blocks of a chain owned by another instance, they are acknowledged once the owner commits them,
+	// so the instance which takes the chain over gets the blocks the owner did not commit
+	standby  []watcher.Block
+	followed time.Time
+	// used to avoid constant spam of invalid height messages if that error occurs
+	ignored bool
+}
+
+func newChainWorker(chainID string, blockProcessor processor.Processor) *chainWorker {
+	return &chainWorker{
+		chainID:   chainID,
+		Processor: blockProcessor,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// push adds block to the queue, false is returned if worker has stopped
+func (w *chainWorker) push(block watcher.Block) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return false
+	}
+	w.queue = append(w.queue, block)
+	w.notify()
+	return true
+}
+
+// close makes worker stop once it has processed queued blocks
+func (w *chainWorker) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	w.notify()
+}
+
+// next returns the first queued block, ok is false if the queue is empty
+func (w *chainWorker) next() (block watcher.Block, ok bool, closed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.queue) == 0 {
+		return nil, false, w.closed
+	}
+	block = w.queue[0]
+	w.queue[0] = nil
+	w.queue = w.queue[1:]
+	return block, true, w.closed
+}
+
+// retire stops worker if nothing was queued while it was idle,
+// worker which keeps blocks of another owner does not stop, since nobody else would acknowledge them
+func (w *chainWorker) retire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.queue) > 0 || len(w.standby) > 0 {
+		return false
+	}
+	w.closed = true
+	return true
+}
+
+func (w *chainWorker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes blocks until worker is closed, it is idle for too long or context is done,
+// slots limit the number of blocks processed at the same time by all workers.
+// Blocks are acknowledged only after they are committed, so blocks which were queued or pending
+// when worker failed are delivered again
+func (w *chainWorker) Run(ctx context.Context, slots chan struct{}) (err error) {
+	defer close(w.done)
+	defer func() {
+		// blocks which were processed but not committed yet must not be lost on shutdown,
+		// unless processor failed and its state can't be trusted
+		if err == nil {
+			err = w.handleError(w.flush(context.Background()))
+		}
+		if closer, ok := w.Processor.(processor.Closer); ok {
+			if err := closer.Close(context.Background()); err != nil {
+				log.Printf("could not close processor of %s: %s\n", w.chainID, err)
+			}
+		}
+	}()
+
+	idleSince := time.Now()
+	for {
+		block, ok, closed := w.next()
+		if !ok {
+			if closed {
+				return nil
+			}
+			select {
+			case <-w.wake:
+			// commit pending blocks if chain went quiet
+			case <-time.After(idleFlushInterval):
+				if err := w.handleError(w.flush(ctx)); err != nil {
+					return err
+				}
+				if err := w.follow(ctx, slots); err != nil {
+					return err
+				}
+				if time.Since(idleSince) >= idleWorkerTimeout && w.retire() {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+
+		// blocks must be processed in order, so they wait behind the ones kept for another owner
+		if len(w.standby) > 0 {
+			w.standby = append(w.standby, block)
+			if time.Since(w.followed) >= followInterval {
+				if err := w.follow(ctx, slots); err != nil {
+					return err
+				}
+			}
+			idleSince = time.Now()
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		err := w.process(ctx, block)
+		<-slots
+		if err != nil {
+			return err
+		}
+		idleSince = time.Now()
+	}
+}
+
+// process processes block, it is acknowledged once processor commits it
+func (w *chainWorker) process(ctx context.Context, block watcher.Block) error {
+	w.unacked = append(w.unacked, block)
+	return w.handleError(w.ProcessBlock(ctx, block))
+}
+
+// follow acknowledges kept blocks which the owner of the chain has already committed
+// and tries to process the rest, they are kept again if the chain still has another owner
+func (w *chainWorker) follow(ctx context.Context, slots chan struct{}) error {
+	if len(w.standby) == 0 {
+		return nil
+	}
+	w.followed = time.Now()
+
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	}
+	defer func() { <-slots }()
+
+	if follower, ok := w.Processor.(processor.Follower); ok {
+		height, err := follower.LastProcessedBlock(ctx, w.chainID)
+		if err != nil {
+			return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+		}
+		for len(w.standby) > 0 && w.standby[0].Height() <= height {
+			ack(w.standby[0])
+			w.standby[0] = nil
+			w.standby = w.standby[1:]
+		}
+	}
+
+	for len(w.standby) > 0 {
+		kept := len(w.standby)
+		block := w.standby[0]
+		w.standby[0] = nil
+		w.standby = w.standby[1:]
+		if err := w.process(ctx, block); err != nil {
+			return err
+		}
+		// block was put back, so the chain still has another owner
+		if len(w.standby) == kept {
+			return nil
+		}
+	}
+	return nil
+}
+
+// flush commits blocks which processor has not committed yet
+func (w *chainWorker) flush(ctx context.Context) error {
+	if flusher, ok := w.Processor.(processor.Flusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
+
+// pending returns number of processed blocks which processor has not committed yet
+func (w *chainWorker) pending() int {
+	if flusher, ok := w.Processor.(processor.Flusher); ok {
+		return flusher.Pending()
+	}
+	return 0
+}
+
+// handleError logs block processing errors and returns the ones which must stop processing,
+// once nothing is pending processed blocks are acknowledged
+func (w *chainWorker) handleError(err error) error {
+	// if we have error in our logic or there is no connection
+	if errors.Is(err, processor.ConnectionError) ||
+		errors.Is(err, processor.CommitError) {
+		return err
+	}
+	// chain belongs to another instance, its block is kept in front of the other kept blocks
+	// until the owner commits it or this instance takes the chain over
+	if errors.Is(err, processor.ChainLockedError) && len(w.unacked) > 0 {
+		last := len(w.unacked) - 1
+		w.standby = append([]watcher.Block{w.unacked[last]}, w.standby...)
+		w.unacked[last] = nil
+		w.unacked = w.unacked[:last]
+	}
+	if w.pending() == 0 {
+		for _, block := range w.unacked {
+			ack(block)
+		}
+		w.unacked = nil
+	}
+
+	// queue was fixed, no need to suppress messagess from it anymore
+	if err == nil {
+		w.ignored = false
+		return nil
+	}
+
+	// log the error if we are not ignoring this chain
+	if !w.ignored {
+		log.Printf("could not process block from %s: %s\n", w.chainID, err)
+	}
+
+	// if order of blocks is messed up or chain belongs to another instance,
+	// don't log its errors until it's fixed or the chain is taken over
+	if errors.Is(err, processor.BlockHeightError) ||
+		errors.Is(err, processor.ChainLockedError) {
+		w.ignored = true
+	}
+	return nil
+}
+
+// ack confirms block to its source if the source expects it
+func ack(block watcher.Block) {
+	if a, ok := block.(processor.Acknowledger); ok {
+		if err := a.Ack(); err != nil {
+			log.Printf("could not acknowledge block %d of %s: %s\n", block.Height(), block.ChainID(), err)
+		}
+	}
+}
+
+func (w *chainWorker) ProcessBlock(ctx context.Context, block watcher.Block) error {
+	err := w.Validate(ctx, block)
+	if err != nil {
+		return err
+	}
+
+	if prefetcher, ok := w.Processor.(processor.Prefetcher); ok {
+		if err := prefetcher.Prefetch(ctx, block); err != nil {
+			return err
+		}
+	}
+
+	for _, message := range block.Messages() {
+		handler := w.Handler(message)
+		if handler != nil {
+			err := handler(ctx, processor.MessageMetadata{
+				ChainID:     block.ChainID(),
+				BlockHeight: block.Height(),
+				BlockTime:   block.Time(),
+			}, message)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.Commit(ctx, block)
+}
@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// pendingBlocks holds data of blocks which were processed but are not committed to db yet,
+// queries of all of them are sent in one batch
+type pendingBlocks struct {
+	batch   *pgx.Batch
+	blocks  int
+	chainID string
+	// heights of the first and the last pending blocks
+	from   int64
+	height int64
+	// when the first pending block was committed
+	since time.Time
+	// topology created by pending blocks, db does not know about it yet
+	clients       map[string]string
+	connections   map[string]string
+	channels      map[string]string
+	channelPorts  map[string]string
+	channelStates map[string]processor.ChannelState
+	// active addresses of zones which store sketches, they are merged on flush
+	addresses []pendingAddresses
+}
+
+// keepPending moves topology of the current block to pending data
+func (p *PostgresProcessor) keepPending(block watcher.Block) {
+	if p.pending.blocks == 0 {
+		p.pending.since = time.Now()
+		p.pending.from = block.Height()
+		p.pending.clients = make(map[string]string)
+		p.pending.connections = make(map[string]string)
+		p.pending.channels = make(map[string]string)
+		p.pending.channelPorts = make(map[string]string)
+		p.pending.channelStates = make(map[string]processor.ChannelState)
+	}
+	p.pending.blocks++
+	p.pending.chainID = block.ChainID()
+	p.pending.height = block.Height()
+
+	for clientID, chainID := range p.clients {
+		p.pending.clients[clientID] = chainID
+	}
+	for connectionID, clientID := range p.connections {
+		p.pending.connections[connectionID] = clientID
+	}
+	for channelID, connectionID := range p.channels {
+		p.pending.channels[channelID] = connectionID
+	}
+	for channelID, portID := range p.channelPorts {
+		p.pending.channelPorts[channelID] = portID
+	}
+	for channelID, state := range p.channelStates {
+		p.pending.channelStates[channelID] = state
+	}
+}
+
+// shouldFlush tells if pending blocks have to be committed after the given block
+func (p *PostgresProcessor) shouldFlush(block watcher.Block, now time.Time) bool {
+	if p.pending.blocks >= p.batchBlocks {
+		return true
+	}
+	if p.batchInterval > 0 && now.Sub(p.pending.since) >= p.batchInterval {
+		return true
+	}
+	// once zone is caught up every block is committed as soon as it's processed
+	return isCaughtUp(block.Time(), now, p.caughtUpThreshold)
+}
+
+// Pending returns number of processed blocks which wait for flush
+func (p *PostgresProcessor) Pending() int {
+	return p.pending.blocks
+}
+
+// Flush commits all pending blocks to db in one transaction, so either all of them are stored or none
+func (p *PostgresProcessor) Flush(ctx context.Context) error {
+	if p.pending.blocks == 0 {
+		return nil
+	}
+	pending := p.pending
+	p.pending = pendingBlocks{}
+
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	// does nothing if transaction is committed
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(lockChainTxQuery, pending.chainID)); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+	// validate blocks again inside of the transaction, so nobody can commit them in between
+	expected, err := p.expectedHeight(ctx, tx, pending.chainID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	if pending.from != expected {
+		p.checkRollback(expected)
+		return fmt.Errorf("%w: expected blocks from height %d, got blocks from height %d", processor.BlockHeightError, expected, pending.from)
+	}
+
+	if err := sendBatch(ctx, tx, pending.batch); err != nil {
+		return err
+	}
+	if err := flushAddressSketches(ctx, tx, pending.chainID, pending.addresses); err != nil {
+		return err
+	}
+	// mark all pending blocks as processed at once, after the batch,
+	// since addZone enables new zone only while it has no blocks_log row
+	tag, err := tx.Exec(ctx, markBlock(pending.chainID, pending.from, pending.height))
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+	if tag.RowsAffected() != 1 {
+		return fmt.Errorf("%w: blocks from height %d are already processed", processor.BlockHeightError, pending.from)
+	}
+	if _, err := tx.Exec(ctx, pruneRollbackLog(pending.chainID, pending.height-p.rollbackRetention)); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+
+	// pending data is in db now, so we can remember channels which were resolved inside of it
+	p.committed = pending.height
+	p.cacheBlockChannels(pending)
+	return nil
+}
+
+// localClient returns chain ID of the client created in the current or one of pending blocks
+func (p *PostgresProcessor) localClient(clientID string) (string, bool) {
+	if chainID, ok := p.clients[clientID]; ok {
+		return chainID, true
+	}
+	chainID, ok := p.pending.clients[clientID]
+	return chainID, ok
+}
+
+// localConnection returns client ID of the connection created in the current or one of pending blocks
+func (p *PostgresProcessor) localConnection(connectionID string) (string, bool) {
+	if clientID, ok := p.connections[connectionID]; ok {
+		return clientID, true
+	}
+	clientID, ok := p.pending.connections[connectionID]
+	return clientID, ok
+}
+
+// localChannel returns connection ID and port of the channel created in the current or one of pending blocks
+func (p *PostgresProcessor) localChannel(channelID string) (string, string, bool) {
+	if connectionID, ok := p.channels[channelID]; ok {
+		return connectionID, p.channelPorts[channelID], true
+	}
+	connectionID, ok := p.pending.channels[channelID]
+	return connectionID, p.pending.channelPorts[channelID], ok
+}
+
+// localChannelState returns channel state changed in the current or one of pending blocks
+func (p *PostgresProcessor) localChannelState(channelID string) (processor.ChannelState, bool) {
+	if state, ok := p.channelStates[channelID]; ok {
+		return state, true
+	}
+	state, ok := p.pending.channelStates[channelID]
+	return state, ok
+}
+
+func sendBatch(ctx context.Context, tx pgx.Tx, batch *pgx.Batch) error {
+	res := tx.SendBatch(ctx, batch)
+	defer res.Close()
+
+	for i := 0; i < batch.Len(); i++ {
+		_, err := res.Exec()
+		if err != nil {
+			return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBlock struct {
+	chainID string
+	height  int64
+	time    time.Time
+	msgs    []watcher.Message
+}
+
+func (b testBlock) Height() int64               { return b.height }
+func (b testBlock) ChainID() string             { return b.chainID }
+func (b testBlock) Time() time.Time             { return b.time }
+func (b testBlock) Messages() []watcher.Message { return b.msgs }
+
+func TestPostgresProcessor_shouldFlush(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		blocks    int
+		interval  time.Duration
+		pending   int
+		since     time.Time
+		blockTime time.Time
+		expected  bool
+	}{
+		{"per_block_commits", 1, 0, 1, now, blockTime, true},
+		{"batch_is_not_full", 10, 0, 5, now, blockTime, false},
+		{"batch_is_full", 10, 0, 10, now, blockTime, true},
+		{"batch_waits_too_long", 10, time.Second, 5, now.Add(-time.Minute), blockTime, true},
+		{"batch_waits_within_interval", 10, time.Minute, 5, now.Add(-time.Second), blockTime, false},
+		{"zone_is_caught_up", 10, 0, 5, now, now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PostgresProcessor{caughtUpThreshold: time.Minute}
+			WithBatching(tt.blocks, tt.interval)(p)
+			p.pending = pendingBlocks{blocks: tt.pending, since: tt.since}
+			assert.Equal(t, tt.expected, p.shouldFlush(testBlock{chainID: "zone1", height: 1, time: tt.blockTime}, now))
+		})
+	}
+}
+
+func TestPostgresProcessor_keepPending(t *testing.T) {
+	p := newTestProcessor()
+	ctx := context.Background()
+	metadata := processor.MessageMetadata{ChainID: "zone1", BlockHeight: 10}
+
+	for _, msg := range []watcher.Message{
+		watcher.CreateClient{ClientID: "client-0", ChainID: "zone2"},
+		watcher.CreateConnection{ConnectionID: "connection-0", ClientID: "client-0"},
+		watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-0", PortID: "transfer"},
+	} {
+		assert.NoError(t, p.Handler(msg)(ctx, metadata, msg))
+	}
+	p.keepPending(testBlock{chainID: "zone1", height: 10})
+	p.reset()
+
+	// next block sees topology of the pending one without querying db
+	channel, err := p.Channel(ctx, "channel-0", "zone1")
+	assert.NoError(t, err)
+	assert.Equal(t, Channel{ChainID: "zone2", PortID: "transfer"}, channel)
+
+	msg := watcher.OpenChannel{ChannelID: "channel-0"}
+	metadata.BlockHeight = 11
+	assert.NoError(t, p.Handler(msg)(ctx, metadata, msg))
+	assert.Equal(t, processor.ChannelOpen, p.channelStates["channel-0"])
+
+	p.keepPending(testBlock{chainID: "zone1", height: 11})
+	assert.Equal(t, 2, p.pending.blocks)
+	assert.Equal(t, int64(11), p.pending.height)
+	assert.Equal(t, processor.ChannelOpen, p.pending.channelStates["channel-0"])
+}
+
+// testZoneTx emulates how rows of a single zone decide whether it gets enabled:
+// addZone enables zone only while it has no blocks_log row and markBlock creates that row
+type testZoneTx struct {
+	pgx.Tx
+	queries   testQuerier
+	logged    bool
+	enabled   bool
+	committed bool
+}
+
+func (tx *testZoneTx) Begin(context.Context) (pgx.Tx, error) { return tx, nil }
+func (tx *testZoneTx) Rollback(context.Context) error        { return nil }
+
+func (tx *testZoneTx) Commit(context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *testZoneTx) Exec(_ context.Context, sql string, _ ...interface{}) (pgconn.CommandTag, error) {
+	if strings.HasPrefix(sql, "insert into blocks_log") {
+		tx.logged = true
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	}
+	return nil, nil
+}
+
+func (tx *testZoneTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return tx.queries.Query(ctx, sql, args...)
+}
+
+// SendBatch runs addZone of the first block, the rest of the batch does not change zone
+func (tx *testZoneTx) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	tx.enabled = !tx.logged
+	return testBatchResults{}
+}
+
+type testBatchResults struct {
+	pgx.BatchResults
+}
+
+func (testBatchResults) Exec() (pgconn.CommandTag, error) { return nil, nil }
+func (testBatchResults) Close() error                     { return nil }
+
+func TestPostgresProcessor_FlushEnablesNewZone(t *testing.T) {
+	tests := []struct {
+		name        string
+		startHeight int64
+		height      int64
+	}{
+		// zone was added disabled by a client of another zone, its first block must enable it
+		{"implicit_zone", 0, 1},
+		{"bootstrapped_zone", 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &testZoneTx{queries: testQuerier{}}
+			if tt.startHeight > 0 {
+				tx.queries[fmt.Sprintf(startHeightQuery, "zone1")] = []int64{tt.startHeight}
+			}
+			p := NewProcessor(&DB{})
+			p.conn = tx
+
+			assert.NoError(t, p.Commit(context.Background(), testBlock{chainID: "zone1", height: tt.height, time: blockTime}))
+			assert.True(t, tx.committed)
+			assert.True(t, tx.logged)
+			assert.True(t, tx.enabled)
+		})
+	}
+}
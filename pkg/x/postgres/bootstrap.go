@@ -0,0 +1,55 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// Bootstrap makes processing of the zone start from the given height.
+// New zone remembers it as its start height, zone which was processed before
+// skips all blocks below the height, it can't be moved back this way, use Rollback for that.
+func (p *PostgresProcessor) Bootstrap(ctx context.Context, chainID string, height int64) error {
+	if height < 1 {
+		return fmt.Errorf("%w: can't bootstrap from height %d", processor.BlockHeightError, height)
+	}
+	// nobody else may process the zone while it's bootstrapped
+	if err := p.db.lockChain(ctx, chainID); err != nil {
+		return err
+	}
+
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	// does nothing if transaction is committed
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(lockChainTxQuery, chainID)); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+	if _, err := tx.Exec(ctx, bootstrapZone(chainID, height)); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+
+	dbHeight, err := lastProcessedBlock(ctx, tx, chainID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	if dbHeight > 0 {
+		if dbHeight >= height-1 {
+			return fmt.Errorf("%w: zone is already processed up to height %d, roll it back instead", processor.BlockHeightError, dbHeight)
+		}
+		if _, err := tx.Exec(ctx, bootstrapBlocksLog(chainID, height, time.Now())); err != nil {
+			return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+	p.pending = pendingBlocks{}
+	return nil
+}
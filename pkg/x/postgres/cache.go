@@ -0,0 +1,86 @@
+package postgres
+
+import "container/list"
+
+// defaultChannelCacheSize is the number of resolved channels kept in memory
+const defaultChannelCacheSize = 10000
+
+// channelKey identifies channel inside of the zone which created it
+type channelKey struct {
+	zone      string
+	channelID string
+}
+
+type channelCacheEntry struct {
+	key     channelKey
+	channel Channel
+}
+
+// channelCache is a bounded LRU cache of (zone, channel) -> counterparty chain ID and port
+// it only stores mappings which are already committed to db, since clients, connections
+// and channels are never overwritten once inserted, entries go stale only if the zone is rolled back
+type channelCache struct {
+	size  int
+	ll    *list.List
+	items map[channelKey]*list.Element
+}
+
+func newChannelCache(size int) *channelCache {
+	return &channelCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[channelKey]*list.Element),
+	}
+}
+
+// checkRollback drops cached channels if db expects a block which this processor has already committed,
+// in that case the zone was rolled back and cached channels may not exist anymore
+func (p *PostgresProcessor) checkRollback(expected int64) {
+	if p.committed > 0 && expected <= p.committed {
+		p.channelCache = newChannelCache(p.channelCache.size)
+		p.committed = 0
+	}
+}
+
+// WithChannelCacheSize sets the number of resolved channels kept in memory, zero disables the cache
+func WithChannelCacheSize(size int) Option {
+	return func(p *PostgresProcessor) {
+		p.channelCache = newChannelCache(size)
+	}
+}
+
+// Get returns cached channel and marks entry as recently used
+func (c *channelCache) Get(zone, channelID string) (Channel, bool) {
+	if e, ok := c.items[channelKey{zone, channelID}]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*channelCacheEntry).channel, true
+	}
+	return Channel{}, false
+}
+
+// Add puts channel into the cache evicting least recently used entry if cache is full
+func (c *channelCache) Add(zone, channelID string, channel Channel) {
+	// we never want to remember unresolved channels
+	if channel.ChainID == "" || c.size <= 0 {
+		return
+	}
+
+	key := channelKey{zone, channelID}
+	if e, ok := c.items[key]; ok {
+		e.Value.(*channelCacheEntry).channel = channel
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&channelCacheEntry{key: key, channel: channel})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*channelCacheEntry).key)
+	}
+}
+
+// Len returns number of cached entries
+func (c *channelCache) Len() int {
+	return c.ll.Len()
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_channelCache(t *testing.T) {
+	type get struct {
+		zone      string
+		channelID string
+		chainID   string
+		ok        bool
+	}
+	tests := []struct {
+		name     string
+		size     int
+		add      [][3]string
+		expected []get
+	}{
+		{
+			"empty_cache",
+			2,
+			nil,
+			[]get{{"zone1", "channel-0", "", false}},
+		},
+		{
+			"same_channel_in_different_zones",
+			2,
+			[][3]string{{"zone1", "channel-0", "chain1"}, {"zone2", "channel-0", "chain2"}},
+			[]get{{"zone1", "channel-0", "chain1", true}, {"zone2", "channel-0", "chain2", true}},
+		},
+		{
+			"evicts_least_recently_used",
+			2,
+			[][3]string{{"zone1", "channel-0", "chain1"}, {"zone1", "channel-1", "chain2"}, {"zone1", "channel-2", "chain3"}},
+			[]get{{"zone1", "channel-0", "", false}, {"zone1", "channel-1", "chain2", true}, {"zone1", "channel-2", "chain3", true}},
+		},
+		{
+			"does_not_cache_unresolved_channels",
+			2,
+			[][3]string{{"zone1", "channel-0", ""}},
+			[]get{{"zone1", "channel-0", "", false}},
+		},
+		{
+			"zero_size_disables_cache",
+			0,
+			[][3]string{{"zone1", "channel-0", "chain1"}},
+			[]get{{"zone1", "channel-0", "", false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChannelCache(tt.size)
+			for _, a := range tt.add {
+				c.Add(a[0], a[1], Channel{ChainID: a[2], PortID: "transfer"})
+			}
+			assert.LessOrEqual(t, c.Len(), tt.size)
+			for _, g := range tt.expected {
+				channel, ok := c.Get(g.zone, g.channelID)
+				assert.Equal(t, g.ok, ok)
+				assert.Equal(t, g.chainID, channel.ChainID)
+			}
+		})
+	}
+}
+
+func Test_channelCache_GetRefreshesEntry(t *testing.T) {
+	c := newChannelCache(2)
+	c.Add("zone1", "channel-0", Channel{ChainID: "chain1"})
+	c.Add("zone1", "channel-1", Channel{ChainID: "chain2"})
+	// channel-0 becomes most recently used, so channel-1 must be evicted
+	c.Get("zone1", "channel-0")
+	c.Add("zone1", "channel-2", Channel{ChainID: "chain3"})
+
+	_, ok := c.Get("zone1", "channel-1")
+	assert.False(t, ok)
+	channel, ok := c.Get("zone1", "channel-0")
+	assert.True(t, ok)
+	assert.Equal(t, "chain1", channel.ChainID)
+}
+
+func TestPostgresProcessor_cacheBlockChannels(t *testing.T) {
+	p := &PostgresProcessor{channelCache: newChannelCache(10)}
+	p.cacheBlockChannels(pendingBlocks{
+		chainID:      "zone1",
+		clients:      map[string]string{"client1": "chain1"},
+		connections:  map[string]string{"connection1": "client1", "connection2": "client2"},
+		channels:     map[string]string{"channel1": "connection1", "channel2": "connection2"},
+		channelPorts: map[string]string{"channel1": "transfer", "channel2": "transfer"},
+	})
+
+	channel, ok := p.channelCache.Get("zone1", "channel1")
+	assert.True(t, ok)
+	assert.Equal(t, Channel{ChainID: "chain1", PortID: "transfer"}, channel)
+
+	// client2 is stored in db, so we can't resolve it without query
+	_, ok = p.channelCache.Get("zone1", "channel2")
+	assert.False(t, ok)
+}
+
+func TestWithChannelCacheSize(t *testing.T) {
+	p := &PostgresProcessor{channelCache: newChannelCache(defaultChannelCacheSize)}
+	WithChannelCacheSize(1)(p)
+
+	p.channelCache.Add("zone1", "channel-0", Channel{ChainID: "chain1"})
+	p.channelCache.Add("zone1", "channel-1", Channel{ChainID: "chain2"})
+	assert.Equal(t, 1, p.channelCache.Len())
+}
+
+func TestPostgresProcessor_checkRollback(t *testing.T) {
+	p := &PostgresProcessor{channelCache: newChannelCache(defaultChannelCacheSize), committed: 10}
+	p.channelCache.Add("zone1", "channel-0", Channel{ChainID: "chain1"})
+
+	// db expects the next block, nothing was rolled back
+	p.checkRollback(11)
+	assert.Equal(t, 1, p.channelCache.Len())
+
+	// db expects a block which was already committed, so channels may be gone
+	p.checkRollback(6)
+	assert.Equal(t, 0, p.channelCache.Len())
+	assert.Equal(t, defaultChannelCacheSize, p.channelCache.size)
+}
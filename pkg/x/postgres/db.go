@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// conn is implemented by connection pool, transactions are started on one of its connections
+type conn interface {
+	querier
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// DB is a pool of connections shared by processors of all chains,
+// so the number of connections does not grow with the number of chains
+type DB struct {
+	pool     *pgxpool.Pool
+	endpoint string
+
+	// chains are owned through session locks of a single connection,
+	// so ownership does not depend on which pooled connection processor gets
+	mu     sync.Mutex
+	locks  *pgx.Conn
+	locked map[string]bool
+}
+
+// Connect opens pool of at most maxConns connections, one more connection is opened
+// once processor takes ownership of its first chain
+func Connect(ctx context.Context, endpoint string, maxConns int) (*DB, error) {
+	config, err := pgxpool.ParseConfig(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if maxConns > 0 {
+		config.MaxConns = int32(maxConns)
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{
+		pool:     pool,
+		endpoint: endpoint,
+		locked:   make(map[string]bool),
+	}, nil
+}
+
+// Close closes all connections, chains owned by this instance are released
+func (db *DB) Close(ctx context.Context) error {
+	db.pool.Close()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.locks == nil {
+		return nil
+	}
+	return db.locks.Close(ctx)
+}
+
+// lockChain makes sure that only this instance works with the chain,
+// lock is taken once and held until instance is closed
+func (db *DB) lockChain(ctx context.Context, chainID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.locked[chainID] {
+		return nil
+	}
+	if db.locks == nil {
+		locks, err := pgx.Connect(ctx, db.endpoint)
+		if err != nil {
+			return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+		}
+		db.locks = locks
+	}
+
+	res, err := db.locks.Query(ctx, fmt.Sprintf(lockChainQuery, chainID))
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+	}
+	defer res.Close()
+
+	locked := false
+	if res.Next() {
+		if err := res.Scan(&locked); err != nil {
+			return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+		}
+	}
+	if err := res.Err(); err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+	}
+	if !locked {
+		return fmt.Errorf("%w: %s", processor.ChainLockedError, chainID)
+	}
+
+	db.locked[chainID] = true
+	return nil
+}
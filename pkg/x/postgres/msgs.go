@@ -3,9 +3,7 @@ package postgres
 import (
 	"context"
 	"fmt"
-	"log"
 	"math/big"
-	"time"
 
 	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
 	processor "github.com/mapofzones/txs-processor/pkg/types"
@@ -17,26 +15,15 @@ func (p *PostgresProcessor) handleTransaction(ctx context.Context, metadata proc
 		panic(fmt.Errorf("%w: could not fetch tx metadata", processor.CommitError))
 	}
 
-	// if tx had errors and did not affect the state
-	if !metadata.TxMetadata.Accepted {
-		for _, m := range msg.Messages {
-			if _, ok := m.(watcher.IBCTransfer); ok {
-				p.txStats.TxWithIBCTransferFail++
-				return nil
-			}
-		}
-		return nil
-	}
-
 	if p.txStats == nil {
-		p.txStats = &processor.TxStats{
-			ChainID: metadata.ChainID,
-			Hour:    metadata.BlockTime.Truncate(time.Hour),
-		}
+		p.txStats = processor.NewTxStats(metadata.ChainID, metadata.BlockTime)
 	}
+	p.txStats.Count++
 
-	if p.txStats.TurnoverAmount == nil {
-		p.txStats.TurnoverAmount = big.NewInt(0)
+	// if tx had errors and did not affect the state
+	if !metadata.TxMetadata.Accepted {
+		p.handleFailedTransaction(msg)
+		return nil
 	}
 
 	hasIBCTransfers := false
@@ -44,18 +31,6 @@ func (p *PostgresProcessor) handleTransaction(ctx context.Context, metadata proc
 	for _, m := range msg.Messages {
 		if _, ok := m.(watcher.IBCTransfer); ok {
 			hasIBCTransfers = true
-			for _, am := range m.(watcher.IBCTransfer).Amount {
-				p.txStats.TurnoverAmount.Add(p.txStats.TurnoverAmount, new(big.Int).SetUint64(am.Amount))
-			}
-			p.txStats.Addresses = append(p.txStats.Addresses, m.(watcher.IBCTransfer).Sender)
-			log.Println(m.(watcher.IBCTransfer).Sender)
-		}
-		if _, ok := m.(watcher.Transfer); ok {
-			for _, am := range m.(watcher.Transfer).Amount {
-				p.txStats.TurnoverAmount.Add(p.txStats.TurnoverAmount, new(big.Int).SetUint64(am.Amount))
-			}
-			p.txStats.Addresses = append(p.txStats.Addresses, m.(watcher.Transfer).Sender)
-			log.Println(m.(watcher.Transfer).Sender)
 		}
 		handle := p.Handler(m)
 		if handle != nil {
@@ -66,15 +41,70 @@ func (p *PostgresProcessor) handleTransaction(ctx context.Context, metadata proc
 		}
 	}
 
-	// increment tx stats
-	p.txStats.Count++
 	// if tx had ibc transfers, mark it
 	if hasIBCTransfers {
 		p.txStats.TxWithIBCTransfer++
 	}
+	return nil
+}
+
+// handleTransactionAddresses puts senders and receivers of accepted tx into active addresses,
+// it must be called after handleTransaction
+func (p *PostgresProcessor) handleTransactionAddresses(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Transaction) error {
+	if p.txStats == nil || !msg.Accepted {
+		return nil
+	}
+
+	for _, m := range msg.Messages {
+		switch m := m.(type) {
+		case watcher.Transfer:
+			p.txStats.Senders.Add(m.Sender)
+			p.txStats.Receivers.Add(m.Recipient)
+		case watcher.IBCTransfer:
+			// only one side of ibc transfer belongs to this chain
+			if m.Source {
+				p.txStats.Senders.Add(m.Sender)
+			} else {
+				p.txStats.Receivers.Add(m.Recipient)
+			}
+		}
+	}
 
-	if len(msg.Sender) > 0 {
-		p.txStats.Addresses = append(p.txStats.Addresses, msg.Sender)
+	// watcher does not fill tx sender yet
+	if msg.Sender != "" {
+		p.txStats.Senders.Add(msg.Sender)
+	}
+	return nil
+}
+
+// handleFailedTransaction accounts failed tx, its messages are not handled since they did not affect the state
+func (p *PostgresProcessor) handleFailedTransaction(msg watcher.Transaction) {
+	p.txStats.TxFailed++
+
+	hasIBCTransfers := false
+	for _, m := range msg.Messages {
+		p.txStats.FailedMessages[m.Type()]++
+		if transfer, ok := m.(watcher.IBCTransfer); ok {
+			hasIBCTransfers = true
+			for _, am := range transfer.Amount {
+				p.txStats.FailedIBCTransferAmount.Add(p.txStats.FailedIBCTransferAmount, new(big.Int).SetUint64(am.Amount))
+			}
+		}
+	}
+
+	if hasIBCTransfers {
+		p.txStats.TxWithIBCTransferFail++
+	}
+}
+
+func (p *PostgresProcessor) handleTransfer(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Transfer) error {
+	// transfers are only accounted as a part of accepted tx
+	if metadata.TxMetadata == nil || p.txStats == nil {
+		return nil
+	}
+
+	for _, am := range msg.Amount {
+		p.txStats.TurnoverAmount.Add(p.txStats.TurnoverAmount, new(big.Int).SetUint64(am.Amount))
 	}
 	return nil
 }
@@ -90,33 +120,79 @@ func (p *PostgresProcessor) handleCreateConnection(ctx context.Context, metadata
 }
 
 func (p *PostgresProcessor) handleCreateChannel(ctx context.Context, metadata processor.MessageMetadata, msg watcher.CreateChannel) error {
+	// duplicate create of the channel which is not committed yet
+	if _, _, ok := p.localChannel(msg.ChannelID); ok {
+		return nil
+	}
 	p.channels[msg.ChannelID] = msg.ConnectionID
+	p.channelPorts[msg.ChannelID] = msg.PortID
+	p.addChannelEvent(metadata, msg.ChannelID, processor.ChannelInit)
 	return nil
 }
 
 func (p *PostgresProcessor) handleOpenChannel(ctx context.Context, metadata processor.MessageMetadata, msg watcher.OpenChannel) error {
-	p.channelStates[msg.ChannelID] = true
-	return nil
+	return p.transitionChannel(ctx, metadata, msg.ChannelID, processor.ChannelOpen)
 }
 
 func (p *PostgresProcessor) handleCloseChannel(ctx context.Context, metadata processor.MessageMetadata, msg watcher.CloseChannel) error {
-	p.channelStates[msg.ChannelID] = false
+	return p.transitionChannel(ctx, metadata, msg.ChannelID, processor.ChannelClosed)
+}
+
+// transitionChannel moves channel to the next state if handshake allows it,
+// invalid transitions are not applied and are returned as StateTransitionError
+func (p *PostgresProcessor) transitionChannel(ctx context.Context, metadata processor.MessageMetadata, channelID string, next processor.ChannelState) error {
+	current, err := p.ChannelState(ctx, channelID, metadata.ChainID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+
+	if !current.CanTransitionTo(next) {
+		return fmt.Errorf("%w: %s at height %d: channel %s can't move from %q to %q",
+			processor.StateTransitionError, metadata.ChainID, metadata.BlockHeight, channelID, current, next)
+	}
+
+	p.channelStates[channelID] = next
+	p.addChannelEvent(metadata, channelID, next)
 	return nil
 }
 
+func (p *PostgresProcessor) addChannelEvent(metadata processor.MessageMetadata, channelID string, state processor.ChannelState) {
+	event := processor.ChannelEvent{
+		ChannelID: channelID,
+		State:     state,
+		Height:    metadata.BlockHeight,
+		Time:      metadata.BlockTime,
+	}
+	if metadata.TxMetadata != nil {
+		event.TxHash = metadata.TxMetadata.Hash
+	}
+	p.channelEvents = append(p.channelEvents, event)
+}
+
 func (p *PostgresProcessor) handleIBCTransfer(ctx context.Context, metadata processor.MessageMetadata, msg watcher.IBCTransfer) error {
-	chainID, err := p.ChainID(ctx, msg.ChannelID, metadata.ChainID)
+	// like transfers, ibc transfers add to turnover only as a part of accepted tx
+	if metadata.TxMetadata != nil && p.txStats != nil {
+		for _, am := range msg.Amount {
+			p.txStats.TurnoverAmount.Add(p.txStats.TurnoverAmount, new(big.Int).SetUint64(am.Amount))
+		}
+	}
+
+	channel, err := p.Channel(ctx, msg.ChannelID, metadata.ChainID)
 	if err != nil {
 		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
 	}
+	chainID := channel.ChainID
 	if chainID == "" {
 		return fmt.Errorf("%w: could not fetch chainID connected to given channelID", processor.CommitError)
 	}
 
+	app := processor.ApplicationFromPort(channel.PortID)
 	if msg.Source {
 		p.ibcStats.Append(metadata.ChainID, chainID, metadata.BlockTime)
+		p.ibcAppStats.Append(app, metadata.ChainID, chainID, metadata.BlockTime)
 	} else {
 		p.ibcStats.Append(chainID, metadata.ChainID, metadata.BlockTime)
+		p.ibcAppStats.Append(app, chainID, metadata.ChainID, metadata.BlockTime)
 	}
 
 	return nil
@@ -0,0 +1,243 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestProcessor returns processor without db connection,
+// it can only be used for messages which don't need to query db
+func newTestProcessor() *PostgresProcessor {
+	p := &PostgresProcessor{channelCache: newChannelCache(defaultChannelCacheSize)}
+	p.reset()
+	p.registerHandlers()
+	return p
+}
+
+func TestPostgresProcessor_transitionChannel(t *testing.T) {
+	metadata := processor.MessageMetadata{ChainID: "zone1", BlockHeight: 10}
+	tests := []struct {
+		name     string
+		msgs     []watcher.Message
+		expected map[string]processor.ChannelState
+	}{
+		{
+			"open_new_channel",
+			[]watcher.Message{
+				watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-0"},
+				watcher.OpenChannel{ChannelID: "channel-0"},
+			},
+			map[string]processor.ChannelState{"channel-0": processor.ChannelOpen},
+		},
+		{
+			"open_and_close_new_channel",
+			[]watcher.Message{
+				watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-0"},
+				watcher.OpenChannel{ChannelID: "channel-0"},
+				watcher.CloseChannel{ChannelID: "channel-0"},
+			},
+			map[string]processor.ChannelState{"channel-0": processor.ChannelClosed},
+		},
+		{
+			"open_channel_twice",
+			[]watcher.Message{
+				watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-0"},
+				watcher.OpenChannel{ChannelID: "channel-0"},
+				watcher.OpenChannel{ChannelID: "channel-0"},
+			},
+			map[string]processor.ChannelState{"channel-0": processor.ChannelOpen},
+		},
+		{
+			"reopen_closed_channel",
+			[]watcher.Message{
+				watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-0"},
+				watcher.CloseChannel{ChannelID: "channel-0"},
+				watcher.OpenChannel{ChannelID: "channel-0"},
+			},
+			map[string]processor.ChannelState{"channel-0": processor.ChannelClosed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProcessor()
+			for _, msg := range tt.msgs {
+				assert.NoError(t, p.Handler(msg)(context.Background(), metadata, msg))
+			}
+			assert.Equal(t, tt.expected, p.channelStates)
+		})
+	}
+}
+
+func TestPostgresProcessor_transitionChannelInvalid(t *testing.T) {
+	metadata := processor.MessageMetadata{ChainID: "zone1", BlockHeight: 10}
+	p := newTestProcessor()
+	p.channels["channel-0"] = "connection-0"
+	p.channelStates["channel-0"] = processor.ChannelClosed
+
+	err := p.transitionChannel(context.Background(), metadata, "channel-0", processor.ChannelOpen)
+	assert.True(t, errors.Is(err, processor.StateTransitionError))
+	assert.Equal(t, processor.ChannelClosed, p.channelStates["channel-0"])
+	assert.Empty(t, p.channelEvents)
+}
+
+func TestPostgresProcessor_handleTransaction(t *testing.T) {
+	metadata := processor.MessageMetadata{ChainID: "zone1", BlockHeight: 10, BlockTime: blockTime}
+	amount := func(a uint64) []struct {
+		Amount uint64
+		Coin   string
+	} {
+		return []struct {
+			Amount uint64
+			Coin   string
+		}{{a, "coin"}}
+	}
+	tests := []struct {
+		name     string
+		txs      []watcher.Transaction
+		expected processor.TxStats
+	}{
+		{
+			"first_tx_failed",
+			[]watcher.Transaction{
+				{Hash: "hash1", Accepted: false, Messages: []watcher.Message{watcher.Transfer{Amount: amount(5)}}},
+			},
+			processor.TxStats{
+				Count:                   1,
+				TxFailed:                1,
+				Senders:                 processor.AddressSet{},
+				Receivers:               processor.AddressSet{},
+				TurnoverAmount:          big.NewInt(0),
+				FailedIBCTransferAmount: big.NewInt(0),
+				FailedMessages:          map[string]int{"transfer": 1},
+			},
+		},
+		{
+			"failed_tx_with_many_ibc_transfers",
+			[]watcher.Transaction{
+				{Hash: "hash1", Accepted: false, Messages: []watcher.Message{
+					watcher.IBCTransfer{ChannelID: "channel-0", Amount: amount(3), Source: true},
+					watcher.IBCTransfer{ChannelID: "channel-0", Amount: amount(4), Source: true},
+				}},
+			},
+			processor.TxStats{
+				Count:                   1,
+				TxFailed:                1,
+				Senders:                 processor.AddressSet{},
+				Receivers:               processor.AddressSet{},
+				TxWithIBCTransferFail:   1,
+				TurnoverAmount:          big.NewInt(0),
+				FailedIBCTransferAmount: big.NewInt(7),
+				FailedMessages:          map[string]int{"ibc_transfer": 2},
+			},
+		},
+		{
+			"accepted_and_failed_txs",
+			[]watcher.Transaction{
+				{Hash: "hash1", Accepted: true, Sender: "sender1", Messages: []watcher.Message{
+					watcher.IBCTransfer{ChannelID: "channel-0", Sender: "sender1", Amount: amount(3), Source: true},
+				}},
+				{Hash: "hash2", Accepted: false, Messages: []watcher.Message{
+					watcher.IBCTransfer{ChannelID: "channel-0", Amount: amount(4), Source: true},
+				}},
+				{Hash: "hash3", Accepted: true, Sender: "sender2", Messages: []watcher.Message{
+					watcher.Transfer{Sender: "sender2", Recipient: "recipient1", Amount: amount(1)},
+				}},
+			},
+			processor.TxStats{
+				Count:                   3,
+				TxFailed:                1,
+				TxWithIBCTransfer:       1,
+				TxWithIBCTransferFail:   1,
+				Senders:                 processor.AddressSet{"sender1": {}, "sender2": {}},
+				Receivers:               processor.AddressSet{"recipient1": {}},
+				TurnoverAmount:          big.NewInt(4),
+				FailedIBCTransferAmount: big.NewInt(4),
+				FailedMessages:          map[string]int{"ibc_transfer": 1},
+			},
+		},
+		{
+			"received_ibc_transfer",
+			[]watcher.Transaction{
+				{Hash: "hash1", Accepted: true, Sender: "relayer1", Messages: []watcher.Message{
+					watcher.IBCTransfer{ChannelID: "channel-0", Sender: "foreign1", Recipient: "recipient1", Amount: amount(2)},
+				}},
+			},
+			processor.TxStats{
+				Count:                   1,
+				TxWithIBCTransfer:       1,
+				Senders:                 processor.AddressSet{"relayer1": {}},
+				Receivers:               processor.AddressSet{"recipient1": {}},
+				TurnoverAmount:          big.NewInt(2),
+				FailedIBCTransferAmount: big.NewInt(0),
+				FailedMessages:          map[string]int{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProcessor()
+			// whole channel handshake happened in this block, so we don't need db to resolve it
+			p.clients["client-0"] = "zone2"
+			p.connections["connection-0"] = "client-0"
+			p.channels["channel-0"] = "connection-0"
+
+			for _, tx := range tt.txs {
+				assert.NoError(t, p.Handler(tx)(context.Background(), metadata, tx))
+			}
+
+			tt.expected.ChainID = metadata.ChainID
+			tt.expected.Hour = blockTime.Truncate(time.Hour)
+			assert.Equal(t, &tt.expected, p.txStats)
+		})
+	}
+}
+
+func TestPostgresProcessor_disabledAddresses(t *testing.T) {
+	p := newTestProcessor()
+	assert.NoError(t, p.Registry().DisableForChains("transaction", "addresses", "*-testnet"))
+
+	tx := watcher.Transaction{Hash: "hash1", Accepted: true, Sender: "sender1", Messages: []watcher.Message{
+		watcher.Transfer{Sender: "sender1", Recipient: "recipient1"},
+	}}
+	metadata := processor.MessageMetadata{ChainID: "spammy-testnet", BlockTime: blockTime}
+	assert.NoError(t, p.Handler(tx)(context.Background(), metadata, tx))
+
+	assert.Equal(t, 1, p.txStats.Count)
+	assert.Empty(t, p.txStats.Senders)
+	assert.Empty(t, p.txStats.Receivers)
+}
+
+func TestPostgresProcessor_handleCreateChannelDuplicate(t *testing.T) {
+	p := newTestProcessor()
+	metadata := processor.MessageMetadata{ChainID: "zone1", BlockHeight: 10}
+	for _, msg := range []watcher.Message{
+		watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-0", PortID: "transfer"},
+		watcher.CreateChannel{ChannelID: "channel-0", ConnectionID: "connection-1", PortID: "icahost"},
+	} {
+		assert.NoError(t, p.Handler(msg)(context.Background(), metadata, msg))
+	}
+
+	// the first create wins and creation is recorded once
+	assert.Equal(t, map[string]string{"channel-0": "connection-0"}, p.channels)
+	assert.Equal(t, "transfer", p.channelPorts["channel-0"])
+	assert.Len(t, p.channelEvents, 1)
+}
+
+func TestPostgresProcessor_handleTransactionAddressesWithoutSender(t *testing.T) {
+	p := newTestProcessor()
+	metadata := processor.MessageMetadata{ChainID: "zone1", BlockHeight: 10, BlockTime: blockTime}
+	tx := watcher.Transaction{Hash: "hash1", Accepted: true, Messages: []watcher.Message{
+		watcher.Transfer{Sender: "address1", Recipient: "address2"},
+	}}
+	assert.NoError(t, p.Handler(tx)(context.Background(), metadata, tx))
+
+	assert.Equal(t, processor.AddressSet{"address1": {}}, p.txStats.Senders)
+	assert.Equal(t, processor.AddressSet{"address2": {}}, p.txStats.Receivers)
+}
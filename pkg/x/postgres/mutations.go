@@ -2,13 +2,15 @@ package postgres
 
 import (
 	"fmt"
+	"strings"
 	"time"
+
+	processor "github.com/mapofzones/txs-processor/pkg/types"
 )
 
-func addZone(chainID string) string {
+func addZone(chainID string, caughtUp bool) string {
 	return fmt.Sprintf(addZoneQuery,
-		fmt.Sprintf("('%s', '%s', %t, %t)", chainID, chainID, true, false),
-		true,
+		fmt.Sprintf("('%s', '%s', %t, %t)", chainID, chainID, true, caughtUp),
 	)
 }
 
@@ -23,37 +25,98 @@ func addImplicitZones(clients map[string]string) string {
 	return fmt.Sprintf(addImplicitZoneQuery, query)
 }
 
-func markBlock(chainID string) string {
+// markBlock marks blocks from..to as processed
+func markBlock(chainID string, from, to int64) string {
 	t := time.Now().Format(Format)
-	return markBlockConstruct(chainID, t)
+	return markBlockConstruct(chainID, from, to, t)
 }
 
-func markBlockConstruct(chainID string, t string) string {
+func markBlockConstruct(chainID string, from, to int64, t string) string {
 	return fmt.Sprintf(markBlockQuery,
-		fmt.Sprintf("('%s', %d, '%s')", chainID, 1, t), t)
-}
-
-//func addTxStats(stats processor.TxStats) string {
-//	return fmt.Sprintf(addTxStatsQuery,
-//		fmt.Sprintf("('%s', '%s', %d, %d, %d, %d, %d)", stats.ChainID, stats.Hour.Format(Format), stats.Count,
-//			stats.TxWithIBCTransfer, 1, stats.TxWithIBCTransferFail, stats.TurnoverAmount),
-//		stats.Count,
-//		stats.TxWithIBCTransfer,
-//		stats.TxWithIBCTransferFail,
-//		stats.TurnoverAmount,
-//	)
-//}
+		fmt.Sprintf("('%s', %d, '%s')", chainID, to, t), from-1)
+}
 
-//func addActiveAddressesStats(stats processor.TxStats, address string) string {
-//	return fmt.Sprintf(addActiveAddressesQuery,
-//		fmt.Sprintf("('%s', '%s', '%s', %d)", address, stats.ChainID, stats.Hour.Format(Format), 1),
-//	)
-//}
+func addTxStats(stats processor.TxStats) string {
+	return fmt.Sprintf(addTxStatsQuery,
+		fmt.Sprintf("('%s', '%s', %d, %d, %d, %d, %d, %d, %d)", stats.ChainID, stats.Hour.Format(Format), stats.Count,
+			stats.TxWithIBCTransfer, 1, stats.TxWithIBCTransferFail, stats.TurnoverAmount, stats.TxFailed,
+			stats.FailedIBCTransferAmount),
+	)
+}
 
-func addClients(origin string, clients map[string]string) string {
+func addFailedMsgsStats(stats processor.TxStats) string {
+	values := ""
+	for msgType, count := range stats.FailedMessages {
+		values += fmt.Sprintf("('%s', '%s', '%s', %d),", stats.ChainID, stats.Hour.Format(Format), msgType, count)
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addFailedMsgsStatsQuery, values)
+}
+
+func addActiveAddresses(stats processor.TxStats, height int64) string {
+	values := ""
+	for _, address := range stats.Addresses().Slice() {
+		values += fmt.Sprintf("('%s', '%s', '%s', %d, %t, %t, %d),", address, stats.ChainID, stats.Hour.Format(Format), 1,
+			stats.Senders.Has(address), stats.Receivers.Has(address), height)
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addActiveAddressesQuery, values)
+}
+
+func addActiveAddressesRollbackLog(stats processor.TxStats, height int64) string {
+	values := ""
+	for _, address := range stats.Addresses().Slice() {
+		values += fmt.Sprintf("('%s', %t, %t),", address, stats.Senders.Has(address), stats.Receivers.Has(address))
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addActiveAddressesRollbackLogQuery, height, values, stats.ChainID, stats.Hour.Format(Format))
+}
+
+func addZoneAddresses(stats processor.TxStats, t time.Time, height int64) string {
+	values := ""
+	for _, address := range stats.Addresses().Slice() {
+		values += fmt.Sprintf("('%s', '%s', '%s', %d),", stats.ChainID, address, t.Format(Format), height)
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addZoneAddressesQuery, values)
+}
+
+func addPeriodActiveAddresses(stats processor.TxStats, height int64) string {
+	values := ""
+	for _, address := range stats.Addresses().Slice() {
+		for _, period := range processor.Periods {
+			values += fmt.Sprintf("('%s', '%s', '%s', '%s', %d),", stats.ChainID, address, period,
+				period.Start(stats.Hour).Format(Format), height)
+		}
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addPeriodActiveAddressesQuery, values)
+}
+
+func addAddressSketch(chainID string, hour time.Time, sketch []byte) string {
+	return fmt.Sprintf(addAddressSketchQuery,
+		fmt.Sprintf("('%s', '%s', decode('%x', 'hex'))", chainID, hour.Format(Format), sketch))
+}
+
+func addClients(origin string, clients map[string]string, height int64) string {
 	values := ""
 	for clientID, chainID := range clients {
-		values += fmt.Sprintf("('%s', '%s', '%s'),", origin, clientID, chainID)
+		values += fmt.Sprintf("('%s', '%s', '%s', %d),", origin, clientID, chainID, height)
 	}
 	if len(values) > 0 {
 		values = values[:len(values)-1]
@@ -61,10 +124,10 @@ func addClients(origin string, clients map[string]string) string {
 	return fmt.Sprintf(addClientsQuery, values)
 }
 
-func addConnections(origin string, data map[string]string) string {
+func addConnections(origin string, data map[string]string, height int64) string {
 	values := ""
 	for connectionID, clientID := range data {
-		values += fmt.Sprintf("('%s', '%s', '%s'),", origin, connectionID, clientID)
+		values += fmt.Sprintf("('%s', '%s', '%s', %d),", origin, connectionID, clientID, height)
 	}
 	if len(values) > 0 {
 		values = values[:len(values)-1]
@@ -73,10 +136,11 @@ func addConnections(origin string, data map[string]string) string {
 	return fmt.Sprintf(addConnectionsQuery, values)
 }
 
-func addChannels(origin string, data map[string]string) string {
+func addChannels(origin string, data, ports map[string]string, height int64, t time.Time) string {
 	values := ""
 	for channelID, connectionID := range data {
-		values += fmt.Sprintf("('%s', '%s', '%s',%t),", origin, channelID, connectionID, false)
+		values += fmt.Sprintf("('%s', '%s', '%s',%t, '%s', %d, '%s', '%s', '%s', %d),", origin, channelID, connectionID, false,
+			processor.ChannelInit, height, t.Format(Format), ports[channelID], processor.ApplicationFromPort(ports[channelID]), height)
 	}
 	if len(values) > 0 {
 		values = values[:len(values)-1]
@@ -85,13 +149,72 @@ func addChannels(origin string, data map[string]string) string {
 	return fmt.Sprintf(addChannelsQuery, values)
 }
 
-func markChannel(origin, channelID string, state bool) string {
+func markChannel(origin, channelID string, state processor.ChannelState, height int64, t time.Time) string {
 	return fmt.Sprintf(markChannelQuery,
+		state == processor.ChannelOpen,
 		state,
+		height,
+		t.Format(Format),
 		origin,
 		channelID)
 }
 
+func addIbcAppStats(origin string, stats []processor.IbcAppStats) string {
+	values := ""
+	for _, s := range stats {
+		values += fmt.Sprintf("('%s', '%s', '%s', '%s', '%s', %d),", origin, s.Application, s.Source,
+			s.Destination, s.Hour.Format(Format), s.Count)
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addIbcAppStatsQuery, values)
+}
+
+func addChannelEvents(origin string, events []processor.ChannelEvent) string {
+	values := ""
+	for _, e := range events {
+		values += fmt.Sprintf("('%s', '%s', '%s', %d, '%s', '%s'),", origin, e.ChannelID, e.State, e.Height,
+			e.Time.Format(Format), e.TxHash)
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addChannelEventsQuery, values)
+}
+
+func addRollbackLog(origin string, height int64, queries []string) string {
+	values := ""
+	for _, query := range queries {
+		values += fmt.Sprintf("('%s', %d, '%s'),", origin, height, strings.ReplaceAll(query, "'", "''"))
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+
+	return fmt.Sprintf(addRollbackLogQuery, values)
+}
+
+func addChannelStateRollbackLog(origin, channelID string, height int64) string {
+	return fmt.Sprintf(addChannelStateRollbackLogQuery, height, origin, channelID)
+}
+
+func pruneRollbackLog(origin string, height int64) string {
+	return fmt.Sprintf(pruneRollbackLogQuery, origin, height)
+}
+
+func bootstrapZone(chainID string, height int64) string {
+	return fmt.Sprintf(bootstrapZoneQuery,
+		fmt.Sprintf("('%s', '%s', %t, %t, %d)", chainID, chainID, false, false, height),
+	)
+}
+
+func bootstrapBlocksLog(chainID string, height int64, t time.Time) string {
+	return fmt.Sprintf(bootstrapBlocksLogQuery, height-1, t.Format(Format), chainID, height-1)
+}
+
 //func addIbcStats(origin string, ibcData map[string]map[string]map[time.Time]int) []string {
 //	// buffer for our queries
 //	queries := make([]string, 0, 32)
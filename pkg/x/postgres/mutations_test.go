@@ -1,31 +1,55 @@
 package postgres
 
 import (
+    processor "github.com/mapofzones/txs-processor/pkg/types"
     "github.com/stretchr/testify/assert"
     "testing"
+    "time"
 )
 
+var blockTime, _ = time.Parse(Format, "2006-01-02T15:04:05")
+
 func Test_addZone(t *testing.T) {
     type args struct {
-        chainID string
+        chainID  string
+        caughtUp bool
     }
     tests := []struct {
         name        string
         args        args
         expected    string
     }{
-        {"empty_args", args{}, "insert into zones(name, chain_id, is_enabled, is_caught_up) values ('', '', true, false)\n    on conflict (chain_id) do update\n        set is_enabled = true;"},
-        {"first_args", args{"myChain1"}, "insert into zones(name, chain_id, is_enabled, is_caught_up) values ('myChain1', 'myChain1', true, false)\n    on conflict (chain_id) do update\n        set is_enabled = true;"},
-        {"second_args", args{"myChain2"}, "insert into zones(name, chain_id, is_enabled, is_caught_up) values ('myChain2', 'myChain2', true, false)\n    on conflict (chain_id) do update\n        set is_enabled = true;"},
+        {"empty_args", args{}, "insert into zones(name, chain_id, is_enabled, is_caught_up) values ('', '', true, false)\n    on conflict (chain_id) do update\n        set is_enabled = zones.is_enabled or not exists (select 1 from blocks_log where zone = excluded.chain_id),\n            is_caught_up = excluded.is_caught_up;"},
+        {"first_args", args{"myChain1", true}, "insert into zones(name, chain_id, is_enabled, is_caught_up) values ('myChain1', 'myChain1', true, true)\n    on conflict (chain_id) do update\n        set is_enabled = zones.is_enabled or not exists (select 1 from blocks_log where zone = excluded.chain_id),\n            is_caught_up = excluded.is_caught_up;"},
+        {"second_args", args{"myChain2", false}, "insert into zones(name, chain_id, is_enabled, is_caught_up) values ('myChain2', 'myChain2', true, false)\n    on conflict (chain_id) do update\n        set is_enabled = zones.is_enabled or not exists (select 1 from blocks_log where zone = excluded.chain_id),\n            is_caught_up = excluded.is_caught_up;"},
     }
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            actual := addZone(tt.args.chainID)
+            actual := addZone(tt.args.chainID, tt.args.caughtUp)
             assert.Equal(t, tt.expected, actual)
         })
     }
 }
 
+func Test_isCaughtUp(t *testing.T) {
+    now := blockTime.Add(time.Hour)
+    tests := []struct {
+        name      string
+        blockTime time.Time
+        threshold time.Duration
+        expected  bool
+    }{
+        {"recent_block", now.Add(-10 * time.Second), time.Minute, true},
+        {"old_block", now.Add(-2 * time.Minute), time.Minute, false},
+        {"block_from_future", now.Add(time.Second), time.Minute, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            assert.Equal(t, tt.expected, isCaughtUp(tt.blockTime, now, tt.threshold))
+        })
+    }
+}
+
 func Test_addImplicitZones(t *testing.T) {
     type args struct {
         clients map[string]string
@@ -50,6 +74,8 @@ func Test_addImplicitZones(t *testing.T) {
 func Test_markBlockConstruct(t *testing.T) {
     type args struct {
         chainID string
+        from    int64
+        to      int64
         time    string
     }
     tests := []struct {
@@ -57,13 +83,13 @@ func Test_markBlockConstruct(t *testing.T) {
         args args
         expected string
     }{
-        {"empty_args", args{}, "insert into blocks_log(zone, last_processed_block, last_updated_at) values ('', 1, '')\n    on conflict (zone) do update\n        set last_processed_block = blocks_log.last_processed_block + 1,\n            last_updated_at = '';"},
-        {"first_args", args{"chainID1", "2006-01-02T15:04:05"}, "insert into blocks_log(zone, last_processed_block, last_updated_at) values ('chainID1', 1, '2006-01-02T15:04:05')\n    on conflict (zone) do update\n        set last_processed_block = blocks_log.last_processed_block + 1,\n            last_updated_at = '2006-01-02T15:04:05';"},
-        {"second_args", args{"chainID2", "2016-12-02T06:14:55"}, "insert into blocks_log(zone, last_processed_block, last_updated_at) values ('chainID2', 1, '2016-12-02T06:14:55')\n    on conflict (zone) do update\n        set last_processed_block = blocks_log.last_processed_block + 1,\n            last_updated_at = '2016-12-02T06:14:55';"},
+        {"empty_args", args{}, "insert into blocks_log(zone, last_processed_block, last_updated_at) values ('', 0, '')\n    on conflict (zone) do update\n        set last_processed_block = excluded.last_processed_block,\n            last_updated_at = excluded.last_updated_at\n        where blocks_log.last_processed_block = -1;"},
+        {"first_block", args{"chainID1", 1, 1, "2006-01-02T15:04:05"}, "insert into blocks_log(zone, last_processed_block, last_updated_at) values ('chainID1', 1, '2006-01-02T15:04:05')\n    on conflict (zone) do update\n        set last_processed_block = excluded.last_processed_block,\n            last_updated_at = excluded.last_updated_at\n        where blocks_log.last_processed_block = 0;"},
+        {"several_blocks", args{"chainID2", 101, 150, "2016-12-02T06:14:55"}, "insert into blocks_log(zone, last_processed_block, last_updated_at) values ('chainID2', 150, '2016-12-02T06:14:55')\n    on conflict (zone) do update\n        set last_processed_block = excluded.last_processed_block,\n            last_updated_at = excluded.last_updated_at\n        where blocks_log.last_processed_block = 100;"},
     }
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            actual := markBlockConstruct(tt.args.chainID, tt.args.time)
+            actual := markBlockConstruct(tt.args.chainID, tt.args.from, tt.args.to, tt.args.time)
             assert.Equal(t, tt.expected, actual)
         })
     }
@@ -73,6 +99,7 @@ func Test_addClients(t *testing.T) {
     type args struct {
         origin  string
         clients map[string]string
+        height  int64
     }
     tests := []struct {
         name string
@@ -82,22 +109,22 @@ func Test_addClients(t *testing.T) {
         {
             "empty_args",
             args{},
-            "insert into ibc_clients(zone, client_id, chain_id) values \n    on conflict (zone, client_id) do nothing;",
+            "insert into ibc_clients(zone, client_id, chain_id, height) values \n    on conflict (zone, client_id) do nothing;",
         },
         {
             "first_args",
-            args{"myOrigin1", map[string]string{"clientID1":"chainID1"}},
-            "insert into ibc_clients(zone, client_id, chain_id) values ('myOrigin1', 'clientID1', 'chainID1')\n    on conflict (zone, client_id) do nothing;",
+            args{"myOrigin1", map[string]string{"clientID1":"chainID1"}, 10},
+            "insert into ibc_clients(zone, client_id, chain_id, height) values ('myOrigin1', 'clientID1', 'chainID1', 10)\n    on conflict (zone, client_id) do nothing;",
         },
         {
             "second_args",
-            args{"myOrigin2", map[string]string{"clientID2":"chainID2"}},
-            "insert into ibc_clients(zone, client_id, chain_id) values ('myOrigin2', 'clientID2', 'chainID2')\n    on conflict (zone, client_id) do nothing;",
+            args{"myOrigin2", map[string]string{"clientID2":"chainID2"}, 20},
+            "insert into ibc_clients(zone, client_id, chain_id, height) values ('myOrigin2', 'clientID2', 'chainID2', 20)\n    on conflict (zone, client_id) do nothing;",
         },
     }
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            actual := addClients(tt.args.origin, tt.args.clients)
+            actual := addClients(tt.args.origin, tt.args.clients, tt.args.height)
             assert.Equal(t, tt.expected, actual)
         })
     }
@@ -107,6 +134,7 @@ func Test_addConnections(t *testing.T) {
     type args struct {
         origin string
         data   map[string]string
+        height int64
     }
     tests := []struct {
         name string
@@ -116,22 +144,22 @@ func Test_addConnections(t *testing.T) {
         {
             "empty_args",
             args{},
-            "insert into ibc_connections(zone, connection_id, client_id) values \n    on conflict (zone, connection_id) do nothing;",
+            "insert into ibc_connections(zone, connection_id, client_id, height) values \n    on conflict (zone, connection_id) do nothing;",
         },
         {
             "first_args",
-            args{"origin1", map[string]string{"connectionID1": "clientID1"}},
-            "insert into ibc_connections(zone, connection_id, client_id) values ('origin1', 'connectionID1', 'clientID1')\n    on conflict (zone, connection_id) do nothing;",
+            args{"origin1", map[string]string{"connectionID1": "clientID1"}, 10},
+            "insert into ibc_connections(zone, connection_id, client_id, height) values ('origin1', 'connectionID1', 'clientID1', 10)\n    on conflict (zone, connection_id) do nothing;",
         },
         {
             "second_args",
-            args{"origin2", map[string]string{"connectionID2": "clientID2"}},
-            "insert into ibc_connections(zone, connection_id, client_id) values ('origin2', 'connectionID2', 'clientID2')\n    on conflict (zone, connection_id) do nothing;",
+            args{"origin2", map[string]string{"connectionID2": "clientID2"}, 20},
+            "insert into ibc_connections(zone, connection_id, client_id, height) values ('origin2', 'connectionID2', 'clientID2', 20)\n    on conflict (zone, connection_id) do nothing;",
         },
     }
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            actual := addConnections(tt.args.origin, tt.args.data)
+            actual := addConnections(tt.args.origin, tt.args.data, tt.args.height)
             assert.Equal(t, tt.expected, actual)
         })
     }
@@ -141,6 +169,9 @@ func Test_addChannels(t *testing.T) {
     type args struct {
         origin string
         data   map[string]string
+        ports  map[string]string
+        height int64
+        t      time.Time
     }
     tests := []struct {
         name string
@@ -150,22 +181,22 @@ func Test_addChannels(t *testing.T) {
         {
             "empty_args",
             args{},
-            "insert into ibc_channels(zone, channel_id, connection_id, is_opened) values \n    on conflict(zone, channel_id) do nothing;",
+            "insert into ibc_channels(zone, channel_id, connection_id, is_opened, state, state_height, state_updated_at, port_id, application, height) values \n    on conflict(zone, channel_id) do nothing;",
         },
         {
             "first_args",
-            args{"origin1", map[string]string{"channelID1": "connectionID1"}},
-            "insert into ibc_channels(zone, channel_id, connection_id, is_opened) values ('origin1', 'channelID1', 'connectionID1',false)\n    on conflict(zone, channel_id) do nothing;",
+            args{"origin1", map[string]string{"channelID1": "connectionID1"}, map[string]string{"channelID1": "transfer"}, 10, blockTime},
+            "insert into ibc_channels(zone, channel_id, connection_id, is_opened, state, state_height, state_updated_at, port_id, application, height) values ('origin1', 'channelID1', 'connectionID1',false, 'INIT', 10, '2006-01-02T15:04:05', 'transfer', 'transfer', 10)\n    on conflict(zone, channel_id) do nothing;",
         },
         {
             "second_args",
-            args{"origin2", map[string]string{"channelID2": "connectionID2"}},
-            "insert into ibc_channels(zone, channel_id, connection_id, is_opened) values ('origin2', 'channelID2', 'connectionID2',false)\n    on conflict(zone, channel_id) do nothing;",
+            args{"origin2", map[string]string{"channelID2": "connectionID2"}, map[string]string{"channelID2": "wasm.contract"}, 20, blockTime},
+            "insert into ibc_channels(zone, channel_id, connection_id, is_opened, state, state_height, state_updated_at, port_id, application, height) values ('origin2', 'channelID2', 'connectionID2',false, 'INIT', 20, '2006-01-02T15:04:05', 'wasm.contract', 'cosmwasm', 20)\n    on conflict(zone, channel_id) do nothing;",
         },
     }
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            actual := addChannels(tt.args.origin, tt.args.data)
+            actual := addChannels(tt.args.origin, tt.args.data, tt.args.ports, tt.args.height, tt.args.t)
             assert.Equal(t, tt.expected, actual)
         })
     }
@@ -175,7 +206,9 @@ func Test_markChannel(t *testing.T) {
     type args struct {
         origin    string
         channelID string
-        state     bool
+        state     processor.ChannelState
+        height    int64
+        t         time.Time
     }
     tests := []struct {
         name string
@@ -185,23 +218,169 @@ func Test_markChannel(t *testing.T) {
         {
             "empty_args",
             args{},
-            "update ibc_channels\n    set is_opened = false\n        where zone = ''\n        and channel_id = '';",
+            "update ibc_channels\n    set is_opened = false,\n        state = '',\n        state_height = 0,\n        state_updated_at = '0001-01-01T00:00:00'\n        where zone = ''\n        and channel_id = '';",
         },
         {
             "first_args",
-            args{"origin1", "myChannelID1", true},
-            "update ibc_channels\n    set is_opened = true\n        where zone = 'origin1'\n        and channel_id = 'myChannelID1';",
+            args{"origin1", "myChannelID1", processor.ChannelOpen, 10, blockTime},
+            "update ibc_channels\n    set is_opened = true,\n        state = 'OPEN',\n        state_height = 10,\n        state_updated_at = '2006-01-02T15:04:05'\n        where zone = 'origin1'\n        and channel_id = 'myChannelID1';",
         },
         {
-           "second_args",
-           args{"origin2", "myChannelID2", false},
-            "update ibc_channels\n    set is_opened = false\n        where zone = 'origin2'\n        and channel_id = 'myChannelID2';",
+            "second_args",
+            args{"origin2", "myChannelID2", processor.ChannelClosed, 20, blockTime},
+            "update ibc_channels\n    set is_opened = false,\n        state = 'CLOSED',\n        state_height = 20,\n        state_updated_at = '2006-01-02T15:04:05'\n        where zone = 'origin2'\n        and channel_id = 'myChannelID2';",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            actual := markChannel(tt.args.origin, tt.args.channelID, tt.args.state, tt.args.height, tt.args.t)
+            assert.Equal(t, tt.expected, actual)
+        })
+    }
+}
+
+func Test_addChannelEvents(t *testing.T) {
+    type args struct {
+        origin string
+        events []processor.ChannelEvent
+    }
+    tests := []struct {
+        name string
+        args args
+        expected string
+    }{
+        {
+            "one_event_outside_tx",
+            args{"origin1", []processor.ChannelEvent{{ChannelID: "channelID1", State: processor.ChannelInit, Height: 10, Time: blockTime}}},
+            "insert into ibc_channel_events(zone, channel_id, state, height, block_time, tx_hash)\n    select e.zone, e.channel_id, e.state, e.height, e.block_time::timestamp, e.tx_hash\n        from (values ('origin1', 'channelID1', 'INIT', 10, '2006-01-02T15:04:05', '')) as e(zone, channel_id, state, height, block_time, tx_hash)\n        where e.state <> 'INIT'\n            or not exists (select 1 from ibc_channels ch\n                where ch.zone = e.zone\n                    and ch.channel_id = e.channel_id\n                    and coalesce(ch.height, 0) < e.height);",
+        },
+        {
+            "many_events",
+            args{"origin2", []processor.ChannelEvent{
+                {ChannelID: "channelID1", State: processor.ChannelOpen, Height: 10, Time: blockTime, TxHash: "hash1"},
+                {ChannelID: "channelID1", State: processor.ChannelClosed, Height: 10, Time: blockTime, TxHash: "hash2"},
+            }},
+            "insert into ibc_channel_events(zone, channel_id, state, height, block_time, tx_hash)\n    select e.zone, e.channel_id, e.state, e.height, e.block_time::timestamp, e.tx_hash\n        from (values ('origin2', 'channelID1', 'OPEN', 10, '2006-01-02T15:04:05', 'hash1'),('origin2', 'channelID1', 'CLOSED', 10, '2006-01-02T15:04:05', 'hash2')) as e(zone, channel_id, state, height, block_time, tx_hash)\n        where e.state <> 'INIT'\n            or not exists (select 1 from ibc_channels ch\n                where ch.zone = e.zone\n                    and ch.channel_id = e.channel_id\n                    and coalesce(ch.height, 0) < e.height);",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            actual := addChannelEvents(tt.args.origin, tt.args.events)
+            assert.Equal(t, tt.expected, actual)
+        })
+    }
+}
+
+func Test_addIbcAppStats(t *testing.T) {
+    type args struct {
+        origin string
+        stats  []processor.IbcAppStats
+    }
+    tests := []struct {
+        name string
+        args args
+        expected string
+    }{
+        {
+            "one_application",
+            args{"origin1", []processor.IbcAppStats{{Application: processor.ApplicationTransfer, IbcStats: processor.IbcStats{Source: "origin1", Destination: "chain1", Hour: blockTime, Count: 2}}}},
+            "insert into ibc_app_hourly_stats(zone, application, source, destination, hour, txs_cnt) values ('origin1', 'transfer', 'origin1', 'chain1', '2006-01-02T15:04:05', 2)\n    on conflict (zone, application, source, destination, hour) do update\n        set txs_cnt = ibc_app_hourly_stats.txs_cnt + excluded.txs_cnt;",
         },
     }
     for _, tt := range tests {
         t.Run(tt.name, func(t *testing.T) {
-            actual := markChannel(tt.args.origin, tt.args.channelID, tt.args.state)
+            actual := addIbcAppStats(tt.args.origin, tt.args.stats)
             assert.Equal(t, tt.expected, actual)
         })
     }
 }
+
+func Test_addTxStats(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+    stats.Count = 5
+    stats.TxFailed = 2
+    stats.TxWithIBCTransfer = 1
+    stats.TxWithIBCTransferFail = 1
+    stats.TurnoverAmount.SetUint64(100)
+    stats.FailedIBCTransferAmount.SetUint64(30)
+
+    expected := "insert into total_tx_hourly_stats(zone, hour, txs_cnt, txs_w_ibc_xfer_cnt, period, txs_w_ibc_xfer_fail_cnt, total_coin_turnover_amount, txs_fail_cnt, ibc_xfer_fail_amount) values ('origin1', '2006-01-02T15:00:00', 5, 1, 1, 1, 100, 2, 30)\n    on conflict (hour, zone, period) do update\n        set txs_cnt = total_tx_hourly_stats.txs_cnt + excluded.txs_cnt,\n            txs_w_ibc_xfer_cnt = total_tx_hourly_stats.txs_w_ibc_xfer_cnt + excluded.txs_w_ibc_xfer_cnt,\n            txs_w_ibc_xfer_fail_cnt = total_tx_hourly_stats.txs_w_ibc_xfer_fail_cnt + excluded.txs_w_ibc_xfer_fail_cnt,\n            total_coin_turnover_amount = total_tx_hourly_stats.total_coin_turnover_amount + excluded.total_coin_turnover_amount,\n            txs_fail_cnt = total_tx_hourly_stats.txs_fail_cnt + excluded.txs_fail_cnt,\n            ibc_xfer_fail_amount = total_tx_hourly_stats.ibc_xfer_fail_amount + excluded.ibc_xfer_fail_amount;"
+    assert.Equal(t, expected, addTxStats(*stats))
+}
+
+func Test_addFailedMsgsStats(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+    stats.FailedMessages["ibc_transfer"] = 2
+
+    expected := "insert into failed_msgs_hourly_stats(zone, hour, msg_type, msgs_cnt) values ('origin1', '2006-01-02T15:00:00', 'ibc_transfer', 2)\n    on conflict (zone, hour, msg_type) do update\n        set msgs_cnt = failed_msgs_hourly_stats.msgs_cnt + excluded.msgs_cnt;"
+    assert.Equal(t, expected, addFailedMsgsStats(*stats))
+}
+
+func Test_addActiveAddresses(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+    stats.Senders.Add("address1")
+    stats.Senders.Add("address2")
+    stats.Receivers.Add("address2")
+
+    expected := "insert into active_addresses(address, zone, hour, period, is_sender, is_receiver, height) values ('address1', 'origin1', '2006-01-02T15:00:00', 1, true, false, 10),('address2', 'origin1', '2006-01-02T15:00:00', 1, true, true, 10)\n    on conflict (address, zone, hour, period) do update\n        set is_sender = active_addresses.is_sender or excluded.is_sender,\n            is_receiver = active_addresses.is_receiver or excluded.is_receiver;"
+    assert.Equal(t, expected, addActiveAddresses(*stats, 10))
+}
+
+func Test_addActiveAddressesRollbackLog(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+    stats.Senders.Add("address1")
+    stats.Receivers.Add("address2")
+
+    expected := "insert into rollback_log(zone, height, query)\n    select a.zone, 10, format('update active_addresses set is_sender = %L, is_receiver = %L where address = %L and zone = %L and hour = %L and period = %L;',\n            a.is_sender, a.is_receiver, a.address, a.zone, a.hour, a.period)\n        from active_addresses a\n        join (values ('address1', true, false),('address2', false, true)) as r(address, is_sender, is_receiver) on r.address = a.address\n        where a.zone = 'origin1'\n            and a.hour = '2006-01-02T15:00:00'\n            and a.period = 1\n            and ((r.is_sender and not a.is_sender) or (r.is_receiver and not a.is_receiver));"
+    assert.Equal(t, expected, addActiveAddressesRollbackLog(*stats, 10))
+}
+
+func Test_addZoneAddresses(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+    stats.Receivers.Add("address1")
+
+    expected := "insert into zone_addresses(zone, address, first_seen_at, height) values ('origin1', 'address1', '2006-01-02T15:04:05', 10)\n    on conflict (zone, address) do nothing;"
+    assert.Equal(t, expected, addZoneAddresses(*stats, blockTime, 10))
+}
+
+func Test_addPeriodActiveAddresses(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+    stats.Senders.Add("address1")
+
+    expected := "with new_addresses as (\n    insert into period_active_addresses(zone, address, period, period_start, height) values ('origin1', 'address1', 'day', '2006-01-02T00:00:00', 10),('origin1', 'address1', 'week', '2006-01-02T00:00:00', 10),('origin1', 'address1', 'month', '2006-01-01T00:00:00', 10)\n        on conflict (zone, address, period, period_start) do nothing\n        returning zone, period, period_start\n)\ninsert into period_active_addresses_stats(zone, period, period_start, addresses_cnt)\n    select zone, period, period_start, count(*) from new_addresses\n        group by zone, period, period_start\n    on conflict (zone, period, period_start) do update\n        set addresses_cnt = period_active_addresses_stats.addresses_cnt + excluded.addresses_cnt;"
+    assert.Equal(t, expected, addPeriodActiveAddresses(*stats, 10))
+}
+
+func Test_addAddressSketch(t *testing.T) {
+    stats := processor.NewTxStats("origin1", blockTime)
+
+    expected := "insert into active_addresses_sketches(zone, hour, sketch) values ('origin1', '2006-01-02T15:00:00', decode('0e0001ff', 'hex'))\n    on conflict (zone, hour) do update\n        set sketch = excluded.sketch;"
+    assert.Equal(t, expected, addAddressSketch(stats.ChainID, stats.Hour, []byte{14, 0, 1, 255}))
+}
+
+func Test_addRollbackLog(t *testing.T) {
+    queries := []string{"update t set v = v - 1 where zone = 'origin1';", "select 1;"}
+
+    expected := "insert into rollback_log(zone, height, query) values ('origin1', 10, 'update t set v = v - 1 where zone = ''origin1'';'),('origin1', 10, 'select 1;');"
+    assert.Equal(t, expected, addRollbackLog("origin1", 10, queries))
+}
+
+func Test_addChannelStateRollbackLog(t *testing.T) {
+    expected := "insert into rollback_log(zone, height, query)\n    select zone, 10, format('update ibc_channels set is_opened = %L, state = %L, state_height = %L, state_updated_at = %L where zone = %L and channel_id = %L;',\n            is_opened, state, state_height, state_updated_at, zone, channel_id)\n        from ibc_channels\n        where zone = 'origin1'\n            and channel_id = 'channel-0';"
+    assert.Equal(t, expected, addChannelStateRollbackLog("origin1", "channel-0", 10))
+}
+
+func Test_pruneRollbackLog(t *testing.T) {
+    expected := "delete from rollback_log where zone = 'origin1' and height <= 9000;"
+    assert.Equal(t, expected, pruneRollbackLog("origin1", 9000))
+}
+
+func Test_bootstrapZone(t *testing.T) {
+    expected := "insert into zones(name, chain_id, is_enabled, is_caught_up, start_height) values ('origin1', 'origin1', false, false, 5000)\n    on conflict (chain_id) do update\n        set start_height = excluded.start_height;"
+    assert.Equal(t, expected, bootstrapZone("origin1", 5000))
+}
+
+func Test_bootstrapBlocksLog(t *testing.T) {
+    expected := "update blocks_log\n    set last_processed_block = 4999,\n        last_updated_at = '2006-01-02T15:04:05'\n    where zone = 'origin1'\n        and last_processed_block < 4999;"
+    assert.Equal(t, expected, bootstrapBlocksLog("origin1", 5000, blockTime))
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import "time"
+
+// defaultCaughtUpThreshold is how far behind wall clock block time can be for zone to be caught up
+const defaultCaughtUpThreshold = time.Minute
+
+// defaultRollbackRetention is the number of latest blocks of zone which can be rolled back
+const defaultRollbackRetention = 1000
+
+// AddressMode defines how active addresses are stored
+type AddressMode int
+
+const (
+	// AddressesExact stores every active address of each hour
+	AddressesExact AddressMode = iota
+	// AddressesSketch stores only hyperloglog sketches of active addresses,
+	// it is meant for busy chains where raw address tables get too heavy
+	AddressesSketch
+)
+
+// Option configures Postgres processor
+type Option func(*PostgresProcessor)
+
+// WithSketchZones stores active addresses of zones matching any of the patterns as sketches,
+// addresses of other zones are stored exactly
+func WithSketchZones(patterns []string) Option {
+	return func(p *PostgresProcessor) {
+		p.sketchZones = patterns
+	}
+}
+
+// WithCaughtUpThreshold sets how far behind wall clock block time can be for zone to be caught up
+func WithCaughtUpThreshold(threshold time.Duration) Option {
+	return func(p *PostgresProcessor) {
+		p.caughtUpThreshold = threshold
+	}
+}
+
+// WithStartHeights sets the first blocks to process of zones which have no processed blocks yet,
+// start heights stored in zones table by bootstrap take precedence over them
+func WithStartHeights(heights map[string]int64) Option {
+	return func(p *PostgresProcessor) {
+		p.startHeights = heights
+	}
+}
+
+// WithRollbackRetention sets the number of latest blocks of zone which can be rolled back,
+// undo queries of older blocks are removed on commit. Processor and admin commands must use the same value
+func WithRollbackRetention(blocks int64) Option {
+	return func(p *PostgresProcessor) {
+		if blocks > 0 {
+			p.rollbackRetention = blocks
+		}
+	}
+}
+
+// WithBatching commits blocks together until there are blocks of them or the first one waits for interval,
+// zero interval means no time limit, blocks of caught up zone are always committed one by one.
+// Interval is checked only when the next block is committed or when chain has been idle for a second,
+// so pending blocks may wait up to about a second longer than that.
+// Pending blocks are acknowledged to the queue only after they are committed
+func WithBatching(blocks int, interval time.Duration) Option {
+	return func(p *PostgresProcessor) {
+		if blocks > 0 {
+			p.batchBlocks = blocks
+		}
+		p.batchInterval = interval
+	}
+}
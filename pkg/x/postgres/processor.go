@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
@@ -11,136 +12,260 @@ import (
 )
 
 // compile time check
-var _ processor.Processor = &PostgresProcessor{}
+var (
+	_ processor.Processor  = &PostgresProcessor{}
+	_ processor.Prefetcher = &PostgresProcessor{}
+	_ processor.Flusher    = &PostgresProcessor{}
+	_ processor.Follower   = &PostgresProcessor{}
+)
 
 type PostgresProcessor struct {
-	conn          *pgx.Conn
+	db            *DB
+	conn          conn
 	txStats       *processor.TxStats
 	ibcStats      processor.IbcData
+	ibcAppStats   processor.IbcAppData
 	clients       map[string]string
 	connections   map[string]string
 	channels      map[string]string
-	channelStates map[string]bool
+	channelPorts  map[string]string
+	channelStates map[string]processor.ChannelState
+	channelEvents []processor.ChannelEvent
+	// channels resolved during previous blocks
+	channelCache *channelCache
+	// height of the last block committed by this processor,
+	// db going below it means that the zone was rolled back
+	committed   int64
+	sketchZones []string
+	registry    *processor.Registry
+	// zone is caught up if its blocks are not older than that
+	caughtUpThreshold time.Duration
+	// blocks are committed together until there are batchBlocks of them
+	// or the first one waits for batchInterval
+	batchBlocks   int
+	batchInterval time.Duration
+	pending       pendingBlocks
+	// first blocks to process of zones which have no processed blocks yet
+	startHeights map[string]int64
+	// number of latest blocks which can be rolled back
+	rollbackRetention int64
 }
 
-// NewProcessor returns instance of Postgres processor
-func NewProcessor(ctx context.Context, dbEndpoint string) (*PostgresProcessor, error) {
-	conn, err := pgx.Connect(ctx, dbEndpoint)
-	if err != nil {
-		return nil, err
-	}
-	return &PostgresProcessor{
-		conn:          conn,
+// NewProcessor returns instance of Postgres processor which uses connections of the given db
+func NewProcessor(db *DB, opts ...Option) *PostgresProcessor {
+	p := &PostgresProcessor{
+		db:            db,
+		conn:          db.pool,
 		clients:       make(map[string]string),
 		connections:   make(map[string]string),
 		channels:      make(map[string]string),
-		channelStates: make(map[string]bool),
+		channelStates: make(map[string]processor.ChannelState),
+		channelPorts:  make(map[string]string),
+		channelCache:  newChannelCache(defaultChannelCacheSize),
 		txStats:       nil,
 		ibcStats:      nil,
-	}, nil
+
+		caughtUpThreshold: defaultCaughtUpThreshold,
+		batchBlocks:       1,
+		rollbackRetention: defaultRollbackRetention,
+	}
+	p.registerHandlers()
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // Validate checks if the block that we received is at valid height
 func (p *PostgresProcessor) Validate(ctx context.Context, b watcher.Block) error {
-	dbHeight, err := p.LastProcessedBlock(ctx, b.ChainID())
-	// something is wrong with our database connection/query
-	if err != nil {
-		return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+	// pending blocks of other chain must not wait for this one
+	if p.pending.blocks > 0 && p.pending.chainID != b.ChainID() {
+		if err := p.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := p.db.lockChain(ctx, b.ChainID()); err != nil {
+		return err
+	}
+
+	expected := p.pending.height + 1
+	if p.pending.blocks == 0 {
+		var err error
+		expected, err = p.expectedHeight(ctx, p.conn, b.ChainID())
+		// something is wrong with our database connection/query
+		if err != nil {
+			return fmt.Errorf("%w: %s", processor.ConnectionError, err)
+		}
+		p.checkRollback(expected)
 	}
 	// received block at wrong height
-	if b.Height()-dbHeight != 1 {
-		return fmt.Errorf("%w: expected block at height %d, got block at height %d", processor.BlockHeightError, dbHeight+1, b.Height())
+	if b.Height() != expected {
+		return fmt.Errorf("%w: expected block at height %d, got block at height %d", processor.BlockHeightError, expected, b.Height())
 	}
 	log.Println(b.ChainID(), "\t", b.Height())
 	return nil
 }
 
-func (p *PostgresProcessor) Handler(watcher.Message) func(context.Context, processor.MessageMetadata, watcher.Message) error {
-	return func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
-
-		switch msg := msg.(type) {
-		case watcher.Transaction:
-			metadata.AddTxMetadata(msg)
-			return p.handleTransaction(ctx, metadata, msg)
-
-		case watcher.CreateClient:
-			return p.handleCreateClient(ctx, metadata, msg)
-
-		case watcher.CreateConnection:
-			return p.handleCreateConnection(ctx, metadata, msg)
-
-		case watcher.CreateChannel:
-			return p.handleCreateChannel(ctx, metadata, msg)
-
-		case watcher.OpenChannel:
-			return p.handleOpenChannel(ctx, metadata, msg)
-
-		case watcher.CloseChannel:
-			return p.handleCloseChannel(ctx, metadata, msg)
-
-		case watcher.IBCTransfer:
-			return p.handleIBCTransfer(ctx, metadata, msg)
+// Handler returns handlers registered for the message type
+func (p *PostgresProcessor) Handler(msg watcher.Message) func(context.Context, processor.MessageMetadata, watcher.Message) error {
+	return p.registry.Handler(msg)
+}
 
-		default:
-			return nil
-		}
+// Registry gives access to message handlers, so they can be extended or disabled
+func (p *PostgresProcessor) Registry() *processor.Registry {
+	return p.registry
+}
 
+// registerHandlers registers handlers of all message types we know about
+func (p *PostgresProcessor) registerHandlers() {
+	p.registry = processor.NewRegistry()
+	handlers := []struct {
+		msg      watcher.Message
+		name     string
+		priority int
+		handle   processor.HandlerFunc
+	}{
+		{watcher.Transaction{}, "transaction", 1, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			metadata.AddTxMetadata(msg.(watcher.Transaction))
+			return p.handleTransaction(ctx, metadata, msg.(watcher.Transaction))
+		}},
+		{watcher.Transaction{}, "addresses", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleTransactionAddresses(ctx, metadata, msg.(watcher.Transaction))
+		}},
+		{watcher.Transfer{}, "transfer", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleTransfer(ctx, metadata, msg.(watcher.Transfer))
+		}},
+		{watcher.CreateClient{}, "create_client", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleCreateClient(ctx, metadata, msg.(watcher.CreateClient))
+		}},
+		{watcher.CreateConnection{}, "create_connection", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleCreateConnection(ctx, metadata, msg.(watcher.CreateConnection))
+		}},
+		{watcher.CreateChannel{}, "create_channel", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleCreateChannel(ctx, metadata, msg.(watcher.CreateChannel))
+		}},
+		{watcher.OpenChannel{}, "open_channel", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleOpenChannel(ctx, metadata, msg.(watcher.OpenChannel))
+		}},
+		{watcher.CloseChannel{}, "close_channel", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleCloseChannel(ctx, metadata, msg.(watcher.CloseChannel))
+		}},
+		{watcher.IBCTransfer{}, "ibc_transfer", 0, func(ctx context.Context, metadata processor.MessageMetadata, msg watcher.Message) error {
+			return p.handleIBCTransfer(ctx, metadata, msg.(watcher.IBCTransfer))
+		}},
+	}
+	for _, h := range handlers {
+		// names are unique, so this can't fail
+		_ = p.registry.Register(h.msg.Type(), h.name, h.priority, h.handle)
 	}
 }
 
+// isCaughtUp tells if block is recent enough for its zone to be considered caught up
+func isCaughtUp(blockTime, now time.Time, threshold time.Duration) bool {
+	return now.Sub(blockTime) <= threshold
+}
+
 func (p *PostgresProcessor) reset() {
 	p.txStats = nil
 	p.ibcStats = nil
+	p.ibcAppStats = nil
 	p.clients = make(map[string]string)
 	p.connections = make(map[string]string)
 	p.channels = make(map[string]string)
-	p.channelStates = make(map[string]bool)
+	p.channelPorts = make(map[string]string)
+	p.channelStates = make(map[string]processor.ChannelState)
+	p.channelEvents = nil
 }
 
+// Commit queues block data to be stored together with other pending blocks
+// and sends them to db once batch is complete
 func (p *PostgresProcessor) Commit(ctx context.Context, block watcher.Block) error {
 	// clear data gathered during block parsing
 	// after we commit block data to db
 	defer p.reset()
 
-	batch := &pgx.Batch{}
+	// queries which reverse counters of this block in case of rollback
+	undo := []string{}
 
-	// add zone
-	batch.Queue(addZone(block.ChainID()))
+	if p.pending.blocks == 0 {
+		p.pending.batch = &pgx.Batch{}
+	}
+	batch := p.pending.batch
 
-	// mark block as processed
-	batch.Queue(markBlock(block.ChainID()))
+	// add zone
+	batch.Queue(addZone(block.ChainID(), isCaughtUp(block.Time(), time.Now(), p.caughtUpThreshold)))
 
 	// insert ibc clients
 	if len(p.clients) > 0 {
 		// add zones to which clients refer
 		batch.Queue(addImplicitZones(p.clients))
 		// now we can add clients
-		batch.Queue(addClients(block.ChainID(), p.clients))
+		batch.Queue(addClients(block.ChainID(), p.clients, block.Height()))
 	}
 
 	// insert ibc connections
 	if len(p.connections) > 0 {
-		batch.Queue(addConnections(block.ChainID(), p.connections))
+		batch.Queue(addConnections(block.ChainID(), p.connections, block.Height()))
 	}
 
 	// insert ibc channels
 	if len(p.channels) > 0 {
-		batch.Queue(addChannels(block.ChainID(), p.channels))
+		batch.Queue(addChannels(block.ChainID(), p.channels, p.channelPorts, block.Height(), block.Time()))
 	}
 
 	// update channelStates
 	for channel, state := range p.channelStates {
-		batch.Queue(markChannel(block.ChainID(), channel, state))
+		batch.Queue(addChannelStateRollbackLog(block.ChainID(), channel, block.Height()))
+		batch.Queue(markChannel(block.ChainID(), channel, state, block.Height(), block.Time()))
 	}
 
-	res := p.conn.SendBatch(ctx, batch)
-	defer res.Close()
-
-	for i := 0; i < batch.Len(); i++ {
-		_, err := res.Exec()
-		if err != nil {
-			return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	// add tx stats of this block
+	if p.txStats != nil {
+		batch.Queue(addTxStats(*p.txStats))
+		undo = append(undo, addTxStats(negTxStats(*p.txStats)))
+		if len(p.txStats.FailedMessages) > 0 {
+			batch.Queue(addFailedMsgsStats(*p.txStats))
+			undo = append(undo, addFailedMsgsStats(negTxStats(*p.txStats)))
+		}
+		if len(p.txStats.Senders)+len(p.txStats.Receivers) > 0 {
+			switch p.addressMode(block.ChainID()) {
+			case AddressesSketch:
+				// sketches are merged on flush, so each hour is written once per batch
+				p.pending.addresses = append(p.pending.addresses, pendingAddresses{
+					height:    block.Height(),
+					hour:      p.txStats.Hour,
+					addresses: p.txStats.Addresses(),
+				})
+			default:
+				batch.Queue(addActiveAddressesRollbackLog(*p.txStats, block.Height()))
+				batch.Queue(addActiveAddresses(*p.txStats, block.Height()))
+				batch.Queue(addZoneAddresses(*p.txStats, block.Time(), block.Height()))
+				batch.Queue(addPeriodActiveAddresses(*p.txStats, block.Height()))
+			}
 		}
 	}
-	return nil
+
+	// add ibc transfers of each application,
+	// watcher reports only ics-20 transfers, so until it reports other packets all of them are "transfer"
+	if len(p.ibcAppStats) > 0 {
+		stats := p.ibcAppStats.ToIbcAppStats()
+		batch.Queue(addIbcAppStats(block.ChainID(), stats))
+		undo = append(undo, addIbcAppStats(block.ChainID(), negIbcAppStats(stats)))
+	}
+
+	// append channel state changes to the history
+	if len(p.channelEvents) > 0 {
+		batch.Queue(addChannelEvents(block.ChainID(), p.channelEvents))
+	}
+
+	if len(undo) > 0 {
+		batch.Queue(addRollbackLog(block.ChainID(), block.Height(), undo))
+	}
+
+	p.keepPending(block)
+	if !p.shouldFlush(block, time.Now()) {
+		return nil
+	}
+	return p.Flush(ctx)
 }
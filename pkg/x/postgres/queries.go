@@ -3,10 +3,24 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
 )
 
+// querier is implemented by both connection and transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
 func (p *PostgresProcessor) LastProcessedBlock(ctx context.Context, chainID string) (int64, error) {
-	res, err := p.conn.Query(ctx, fmt.Sprintf(lastProcessedBlockQuery, chainID))
+	return lastProcessedBlock(ctx, p.conn, chainID)
+}
+
+func lastProcessedBlock(ctx context.Context, q querier, chainID string) (int64, error) {
+	res, err := q.Query(ctx, fmt.Sprintf(lastProcessedBlockQuery, chainID))
 	if err != nil {
 		return -1, err
 	}
@@ -24,6 +38,56 @@ func (p *PostgresProcessor) LastProcessedBlock(ctx context.Context, chainID stri
 	return 0, nil
 }
 
+// StartHeight returns the first block of the zone which has to be processed,
+// it's taken from zones table where bootstrap stores it, then from configuration and defaults to 1
+func (p *PostgresProcessor) StartHeight(ctx context.Context, chainID string) (int64, error) {
+	return p.startHeight(ctx, p.conn, chainID)
+}
+
+func (p *PostgresProcessor) startHeight(ctx context.Context, q querier, chainID string) (int64, error) {
+	res, err := q.Query(ctx, fmt.Sprintf(startHeightQuery, chainID))
+	if err != nil {
+		return -1, err
+	}
+	defer res.Close()
+
+	height, stored := int64(0), false
+	if res.Next() {
+		if err := res.Scan(&height); err != nil {
+			return -1, err
+		}
+		stored = true
+	}
+	if err := res.Err(); err != nil {
+		return -1, err
+	}
+
+	configured, ok := p.startHeights[chainID]
+	switch {
+	case stored && ok && configured != height:
+		log.Printf("%s is bootstrapped from height %d, configured start height %d is ignored\n", chainID, height, configured)
+		return height, nil
+	case stored:
+		return height, nil
+	case ok:
+		return configured, nil
+	}
+	return 1, nil
+}
+
+// expectedHeight returns height of the next block of the zone which has to be processed
+func (p *PostgresProcessor) expectedHeight(ctx context.Context, q querier, chainID string) (int64, error) {
+	dbHeight, err := lastProcessedBlock(ctx, q, chainID)
+	if err != nil {
+		return -1, err
+	}
+	// zone has no processed blocks yet
+	if dbHeight == 0 {
+		return p.startHeight(ctx, q, chainID)
+	}
+	return dbHeight + 1, nil
+}
+
 func (p *PostgresProcessor) ChainIDFromClientID(ctx context.Context, clientID, originChainID string) (string, error) {
 	res, err := p.conn.Query(ctx, fmt.Sprintf(chainIDFromClientIDQuery, clientID, originChainID))
 	if err != nil {
@@ -44,61 +108,226 @@ func (p *PostgresProcessor) ChainIDFromClientID(ctx context.Context, clientID, o
 }
 
 func (p *PostgresProcessor) ChainIDFromConnectionID(ctx context.Context, connectionID, originChainID string) (string, error) {
-	res, err := p.conn.Query(ctx, fmt.Sprintf(clientIDFromConnectionIDQuery, connectionID, originChainID))
+	res, err := p.conn.Query(ctx, fmt.Sprintf(chainIDFromConnectionIDQuery, connectionID, originChainID))
 	if err != nil {
 		return "", err
 	}
 	defer res.Close()
 
 	if res.Next() {
-		clientID := ""
-		err = res.Scan(&clientID)
+		chainID := ""
+		err = res.Scan(&chainID)
 		if err != nil {
 			return "", err
 		}
-		res.Close()
-		return p.ChainIDFromClientID(ctx, clientID, originChainID)
+		return chainID, nil
 	}
 	return "", nil
 }
 
 func (p *PostgresProcessor) ChainIDFromChannelID(ctx context.Context, channelID, originChainID string) (string, error) {
-	res, err := p.conn.Query(ctx, fmt.Sprintf(connectionIDFromChannelIDQuery, channelID, originChainID))
+	channels, err := p.ChannelsFromChannelIDs(ctx, []string{channelID}, originChainID)
 	if err != nil {
 		return "", err
 	}
+	return channels[channelID].ChainID, nil
+}
 
+// Channel holds counterparty chain ID and port of the resolved channel
+type Channel struct {
+	ChainID string
+	PortID  string
+}
+
+// ChannelsFromChannelIDs resolves all given channels in one query,
+// channels which are not present in db are omitted from the result
+func (p *PostgresProcessor) ChannelsFromChannelIDs(ctx context.Context, channelIDs []string, originChainID string) (map[string]Channel, error) {
+	channels := make(map[string]Channel, len(channelIDs))
+	if len(channelIDs) == 0 {
+		return channels, nil
+	}
+
+	res, err := p.conn.Query(ctx, channelsFromChannelIDs(originChainID, channelIDs))
+	if err != nil {
+		return nil, err
+	}
 	defer res.Close()
 
-	if res.Next() {
-		connectionID := ""
-		err = res.Scan(&connectionID)
+	for res.Next() {
+		channelID, channel := "", Channel{}
+		err = res.Scan(&channelID, &channel.ChainID, &channel.PortID)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		res.Close()
-		return p.ChainIDFromConnectionID(ctx, connectionID, originChainID)
+		channels[channelID] = channel
 	}
-	return "", nil
+	return channels, res.Err()
+}
+
+func channelsFromChannelIDs(originChainID string, channelIDs []string) string {
+	values := ""
+	for _, channelID := range channelIDs {
+		values += fmt.Sprintf("'%s',", channelID)
+	}
+	if len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+	return fmt.Sprintf(channelsFromChannelIDsQuery, originChainID, values)
 }
 
 // ChainID method returns chain ID related to the given channel_id
 // it checks for local(block) data and does appropriate db queries
 func (p *PostgresProcessor) ChainID(ctx context.Context, channelID, originChainID string) (string, error) {
+	channel, err := p.Channel(ctx, channelID, originChainID)
+	if err != nil {
+		return "", err
+	}
+	return channel.ChainID, nil
+}
+
+// Channel method returns counterparty chain ID and port of the given channel_id
+// it checks for local(block) data and cached channels before querying db
+func (p *PostgresProcessor) Channel(ctx context.Context, channelID, originChainID string) (Channel, error) {
 	// check block cache before attempting to query db
-	// if whole chain of events(client -> connection -> channel) happened in the same block
-	if chainID, ok := p.clients[p.connections[p.channels[channelID]]]; ok {
+	// if channel was created in the same block or in one of pending blocks
+	if connectionID, portID, ok := p.localChannel(channelID); ok {
+		chainID, err := p.blockConnectionChainID(ctx, connectionID, originChainID)
+		if err != nil {
+			return Channel{}, err
+		}
+		return Channel{ChainID: chainID, PortID: portID}, nil
+	}
+
+	// channel was resolved during one of the previous blocks
+	if channel, ok := p.channelCache.Get(originChainID, channelID); ok {
+		return channel, nil
+	}
+
+	// nothing in cache, query db
+	channels, err := p.ChannelsFromChannelIDs(ctx, []string{channelID}, originChainID)
+	if err != nil {
+		return Channel{}, err
+	}
+	p.channelCache.Add(originChainID, channelID, channels[channelID])
+	return channels[channelID], nil
+}
+
+// blockConnectionChainID returns chain ID of the connection used by channel created in this block
+func (p *PostgresProcessor) blockConnectionChainID(ctx context.Context, connectionID, originChainID string) (string, error) {
+	clientID, ok := p.localConnection(connectionID)
+	// if whole chain of events(client -> connection -> channel) happened in not committed blocks
+	if chainID, ok := p.localClient(clientID); ok {
 		return chainID, nil
 	}
-	// if connection and channel happened in this block
-	if clientID, ok := p.connections[p.channels[channelID]]; ok {
+	// if connection and channel happened in not committed blocks
+	if ok {
 		return p.ChainIDFromClientID(ctx, clientID, originChainID)
 	}
-	// if channel was created in the same block
-	if connectionID, ok := p.channels[channelID]; ok {
-		return p.ChainIDFromConnectionID(ctx, connectionID, originChainID)
+	// only channel was created in this block
+	return p.ChainIDFromConnectionID(ctx, connectionID, originChainID)
+}
+
+// cacheBlockChannels puts channels whose whole chain of events(client -> connection -> channel)
+// happened inside of the pending blocks into the channel cache
+// must be called only after pending blocks were committed
+func (p *PostgresProcessor) cacheBlockChannels(pending pendingBlocks) {
+	for channelID, connectionID := range pending.channels {
+		if chainID, ok := pending.clients[pending.connections[connectionID]]; ok {
+			p.channelCache.Add(pending.chainID, channelID, Channel{ChainID: chainID, PortID: pending.channelPorts[channelID]})
+		}
+	}
+}
+
+// ChannelState returns the latest known state of the channel
+// it checks for local(block) data before querying db, empty state means that channel is unknown
+func (p *PostgresProcessor) ChannelState(ctx context.Context, channelID, originChainID string) (processor.ChannelState, error) {
+	if state, ok := p.localChannelState(channelID); ok {
+		return state, nil
+	}
+	// watcher does not distinguish openInit from openTry, so new channels always start as INIT
+	if _, _, ok := p.localChannel(channelID); ok {
+		return processor.ChannelInit, nil
 	}
 
-	// nothing in cache, query db
-	return p.ChainIDFromChannelID(ctx, channelID, originChainID)
+	res, err := p.conn.Query(ctx, fmt.Sprintf(channelStateQuery, channelID, originChainID))
+	if err != nil {
+		return "", err
+	}
+	defer res.Close()
+
+	if res.Next() {
+		state := ""
+		err = res.Scan(&state)
+		if err != nil {
+			return "", err
+		}
+		return processor.ChannelState(state), nil
+	}
+	return "", nil
+}
+
+// ChannelEvents returns the history of channel states ordered by height
+func (p *PostgresProcessor) ChannelEvents(ctx context.Context, channelID, originChainID string) ([]processor.ChannelEvent, error) {
+	res, err := p.conn.Query(ctx, fmt.Sprintf(channelEventsQuery, channelID, originChainID))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	events := []processor.ChannelEvent{}
+	for res.Next() {
+		event := processor.ChannelEvent{ChannelID: channelID}
+		state := ""
+		err = res.Scan(&state, &event.Height, &event.Time, &event.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		event.State = processor.ChannelState(state)
+		events = append(events, event)
+	}
+	return events, res.Err()
+}
+
+// Prefetch resolves all channels referenced by block's ibc transfers
+// in one query, so handlers don't have to query db for every transfer
+func (p *PostgresProcessor) Prefetch(ctx context.Context, block watcher.Block) error {
+	missing := []string{}
+	for _, channelID := range ibcTransferChannels(block.Messages()) {
+		if _, ok := p.channelCache.Get(block.ChainID(), channelID); !ok {
+			missing = append(missing, channelID)
+		}
+	}
+
+	channels, err := p.ChannelsFromChannelIDs(ctx, missing, block.ChainID())
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	for channelID, channel := range channels {
+		p.channelCache.Add(block.ChainID(), channelID, channel)
+	}
+	return nil
+}
+
+// ibcTransferChannels returns unique channel IDs of ibc transfers, including ones nested in txs
+func ibcTransferChannels(msgs []watcher.Message) []string {
+	seen := map[string]bool{}
+	channels := []string{}
+
+	var walk func([]watcher.Message)
+	walk = func(msgs []watcher.Message) {
+		for _, msg := range msgs {
+			switch msg := msg.(type) {
+			case watcher.Transaction:
+				walk(msg.Messages)
+			case watcher.IBCTransfer:
+				if !seen[msg.ChannelID] {
+					seen[msg.ChannelID] = true
+					channels = append(channels, msg.ChannelID)
+				}
+			}
+		}
+	}
+	walk(msgs)
+
+	return channels
 }
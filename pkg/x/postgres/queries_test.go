@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	watcher "github.com/mapofzones/cosmos-watcher/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_channelsFromChannelIDs(t *testing.T) {
+	type args struct {
+		origin     string
+		channelIDs []string
+	}
+	tests := []struct {
+		name     string
+		args     args
+		expected string
+	}{
+		{
+			"one_channel",
+			args{"origin1", []string{"channel-0"}},
+			"select ch.channel_id, cl.chain_id, coalesce(ch.port_id, 'transfer') from ibc_channels ch\n\tjoin ibc_connections co on co.zone = ch.zone\n\t\tand co.connection_id = ch.connection_id\n\tjoin ibc_clients cl on cl.zone = co.zone\n\t\tand cl.client_id = co.client_id\n\twhere ch.zone = 'origin1'\n\t\tand ch.channel_id in ('channel-0');",
+		},
+		{
+			"many_channels",
+			args{"origin2", []string{"channel-0", "channel-1"}},
+			"select ch.channel_id, cl.chain_id, coalesce(ch.port_id, 'transfer') from ibc_channels ch\n\tjoin ibc_connections co on co.zone = ch.zone\n\t\tand co.connection_id = ch.connection_id\n\tjoin ibc_clients cl on cl.zone = co.zone\n\t\tand cl.client_id = co.client_id\n\twhere ch.zone = 'origin2'\n\t\tand ch.channel_id in ('channel-0','channel-1');",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := channelsFromChannelIDs(tt.args.origin, tt.args.channelIDs)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_ibcTransferChannels(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgs     []watcher.Message
+		expected []string
+	}{
+		{"no_messages", nil, []string{}},
+		{"no_transfers", []watcher.Message{watcher.Transfer{}, watcher.OpenChannel{ChannelID: "channel-0"}}, []string{}},
+		{
+			"transfers_inside_txs",
+			[]watcher.Message{
+				watcher.IBCTransfer{ChannelID: "channel-0"},
+				watcher.Transaction{Messages: []watcher.Message{
+					watcher.IBCTransfer{ChannelID: "channel-1"},
+					watcher.IBCTransfer{ChannelID: "channel-0"},
+				}},
+			},
+			[]string{"channel-0", "channel-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ibcTransferChannels(tt.msgs))
+		})
+	}
+}
+
+// testRows returns rows of a single bigint column
+type testRows struct {
+	pgx.Rows
+	values []int64
+	next   int
+}
+
+func (r *testRows) Next() bool {
+	r.next++
+	return r.next <= len(r.values)
+}
+
+func (r *testRows) Scan(dest ...interface{}) error {
+	*dest[0].(*int64) = r.values[r.next-1]
+	return nil
+}
+
+func (r *testRows) Close()     {}
+func (r *testRows) Err() error { return nil }
+
+// testQuerier answers known queries, other ones return no rows
+type testQuerier map[string][]int64
+
+func (q testQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return &testRows{values: q[sql]}, nil
+}
+
+func TestPostgresProcessor_expectedHeight(t *testing.T) {
+	lastProcessed := fmt.Sprintf(lastProcessedBlockQuery, "zone1")
+	startHeight := fmt.Sprintf(startHeightQuery, "zone1")
+	tests := []struct {
+		name       string
+		configured map[string]int64
+		db         testQuerier
+		expected   int64
+	}{
+		{"new_zone", nil, testQuerier{}, 1},
+		{"configured", map[string]int64{"zone1": 5000}, testQuerier{}, 5000},
+		{"bootstrapped", nil, testQuerier{startHeight: {7000}}, 7000},
+		{"bootstrapped_and_configured", map[string]int64{"zone1": 5000}, testQuerier{startHeight: {7000}}, 7000},
+		{"processed", map[string]int64{"zone1": 5000}, testQuerier{lastProcessed: {8000}, startHeight: {7000}}, 8001},
+		{"other_zone_configured", map[string]int64{"zone2": 5000}, testQuerier{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PostgresProcessor{}
+			WithStartHeights(tt.configured)(p)
+
+			height, err := p.expectedHeight(context.Background(), tt.db, "zone1")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, height)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// Rollback removes effects of the zone's blocks above the given height and moves blocks_log back to it.
+// Rows written once (topology, channel events, addresses) are removed by their height,
+// counters, channel states, address roles and address sketches are restored by queries recorded in rollback_log during commit.
+// Only the latest blocks within rollback retention can be rolled back.
+func (p *PostgresProcessor) Rollback(ctx context.Context, chainID string, height int64) error {
+	if height < 0 {
+		return fmt.Errorf("%w: can't roll back to height %d", processor.BlockHeightError, height)
+	}
+	// nobody else may process the zone while it's rolled back
+	if err := p.db.lockChain(ctx, chainID); err != nil {
+		return err
+	}
+
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	// does nothing if transaction is committed
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(lockChainTxQuery, chainID)); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+	dbHeight, err := lastProcessedBlock(ctx, tx, chainID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+	if height > dbHeight {
+		return fmt.Errorf("%w: can't roll back to height %d, last processed block is %d", processor.BlockHeightError, height, dbHeight)
+	}
+	if height < dbHeight-p.rollbackRetention {
+		return fmt.Errorf("%w: can't roll back to height %d, only %d latest blocks are kept in rollback log", processor.BlockHeightError, height, p.rollbackRetention)
+	}
+
+	undo, err := undoQueries(ctx, tx, chainID, height)
+	if err != nil {
+		return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+	}
+
+	batch := &pgx.Batch{}
+	for _, query := range append(undo, rollbackQueries(chainID, height, time.Now())...) {
+		batch.Queue(query)
+	}
+	if err := sendBatch(ctx, tx, batch); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: %s", processor.CommitError, err.Error())
+	}
+
+	// removed channels must not be resolved from memory
+	p.pending = pendingBlocks{}
+	p.channelCache = newChannelCache(p.channelCache.size)
+	return nil
+}
+
+// undoQueries returns queries recorded by blocks above the height, latest blocks go first
+func undoQueries(ctx context.Context, q querier, chainID string, height int64) ([]string, error) {
+	res, err := q.Query(ctx, fmt.Sprintf(undoQueriesQuery, chainID, height))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	queries := []string{}
+	for res.Next() {
+		query := ""
+		if err := res.Scan(&query); err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	return queries, res.Err()
+}
+
+// rollbackQueries returns queries which remove rows created above the height
+func rollbackQueries(chainID string, height int64, t time.Time) []string {
+	return []string{
+		fmt.Sprintf(rollbackChannelEventsQuery, chainID, height),
+		fmt.Sprintf(rollbackChannelsQuery, chainID, height),
+		fmt.Sprintf(rollbackConnectionsQuery, chainID, height),
+		fmt.Sprintf(rollbackClientsQuery, chainID, height),
+		fmt.Sprintf(rollbackActiveAddressesQuery, chainID, height),
+		fmt.Sprintf(rollbackZoneAddressesQuery, chainID, height),
+		fmt.Sprintf(rollbackPeriodActiveAddressesQuery, chainID, height),
+		fmt.Sprintf(rollbackLogQuery, chainID, height),
+		fmt.Sprintf(rollbackBlocksLogQuery, height, t.Format(Format), chainID),
+	}
+}
+
+// negTxStats returns stats which cancel the given ones when added to db
+func negTxStats(stats processor.TxStats) processor.TxStats {
+	neg := stats
+	neg.Count = -stats.Count
+	neg.TxFailed = -stats.TxFailed
+	neg.TxWithIBCTransfer = -stats.TxWithIBCTransfer
+	neg.TxWithIBCTransferFail = -stats.TxWithIBCTransferFail
+	neg.TurnoverAmount = new(big.Int).Neg(stats.TurnoverAmount)
+	neg.FailedIBCTransferAmount = new(big.Int).Neg(stats.FailedIBCTransferAmount)
+	neg.FailedMessages = make(map[string]int, len(stats.FailedMessages))
+	for msgType, count := range stats.FailedMessages {
+		neg.FailedMessages[msgType] = -count
+	}
+	return neg
+}
+
+// negIbcAppStats returns stats which cancel the given ones when added to db
+func negIbcAppStats(stats []processor.IbcAppStats) []processor.IbcAppStats {
+	neg := make([]processor.IbcAppStats, len(stats))
+	for i, s := range stats {
+		s.Count = -s.Count
+		neg[i] = s
+	}
+	return neg
+}
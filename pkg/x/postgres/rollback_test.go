@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"math/big"
+	"testing"
+
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_negTxStats(t *testing.T) {
+	stats := processor.NewTxStats("zone1", blockTime)
+	stats.Count = 5
+	stats.TxFailed = 2
+	stats.TxWithIBCTransfer = 1
+	stats.TxWithIBCTransferFail = 1
+	stats.TurnoverAmount.SetUint64(100)
+	stats.FailedIBCTransferAmount.SetUint64(30)
+	stats.FailedMessages["ibc_transfer"] = 2
+
+	neg := negTxStats(*stats)
+	assert.Equal(t, -5, neg.Count)
+	assert.Equal(t, -2, neg.TxFailed)
+	assert.Equal(t, -1, neg.TxWithIBCTransfer)
+	assert.Equal(t, -1, neg.TxWithIBCTransferFail)
+	assert.Equal(t, big.NewInt(-100), neg.TurnoverAmount)
+	assert.Equal(t, big.NewInt(-30), neg.FailedIBCTransferAmount)
+	assert.Equal(t, map[string]int{"ibc_transfer": -2}, neg.FailedMessages)
+
+	// original stats are left untouched
+	assert.Equal(t, 5, stats.Count)
+	assert.Equal(t, big.NewInt(100), stats.TurnoverAmount)
+	assert.Equal(t, 2, stats.FailedMessages["ibc_transfer"])
+}
+
+func Test_rollbackQueries(t *testing.T) {
+	queries := rollbackQueries("zone1", 100, blockTime)
+
+	// rows are removed only for the zone and above the height
+	for _, query := range queries[:len(queries)-1] {
+		assert.Contains(t, query, "zone = 'zone1'")
+		assert.Contains(t, query, "> 100")
+	}
+	assert.Equal(t, "delete from ibc_channel_events where zone = 'zone1' and height > 100;", queries[0])
+	assert.Equal(t, "update blocks_log\n    set last_processed_block = 100,\n        last_updated_at = '2006-01-02T15:04:05'\n    where zone = 'zone1';", queries[len(queries)-1])
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/mapofzones/txs-processor/pkg/hyperloglog"
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+)
+
+// pendingAddresses are active addresses of a pending block which are merged into the hourly sketch on flush
+type pendingAddresses struct {
+	height    int64
+	hour      time.Time
+	addresses processor.AddressSet
+}
+
+// addressMode tells how active addresses of the zone are stored
+func (p *PostgresProcessor) addressMode(chainID string) AddressMode {
+	if processor.MatchChain(p.sketchZones, chainID) {
+		return AddressesSketch
+	}
+	return AddressesExact
+}
+
+// flushAddressSketches merges addresses of pending blocks into hourly sketches inside of the flush transaction,
+// so the sketches can't be changed between read and write, every hour is written once,
+// while each block gets query which restores the sketch it started from
+func flushAddressSketches(ctx context.Context, tx pgx.Tx, chainID string, pending []pendingAddresses) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	sketches := make(map[time.Time]*hyperloglog.Sketch)
+	hours := []time.Time{}
+	undo := make(map[int64][]string)
+	heights := []int64{}
+	for _, block := range pending {
+		sketch, ok := sketches[block.hour]
+		if !ok {
+			var err error
+			sketch, err = addressSketch(ctx, tx, chainID, block.hour)
+			if err != nil {
+				return fmt.Errorf("%w: %s", processor.ConnectionError, err.Error())
+			}
+			sketches[block.hour] = sketch
+			hours = append(hours, block.hour)
+		}
+		previous, err := sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, ok := undo[block.height]; !ok {
+			heights = append(heights, block.height)
+		}
+		undo[block.height] = append(undo[block.height], addAddressSketch(chainID, block.hour, previous))
+
+		for address := range block.addresses {
+			sketch.Insert(address)
+		}
+	}
+
+	batch := &pgx.Batch{}
+	for _, hour := range hours {
+		data, err := sketches[hour].MarshalBinary()
+		if err != nil {
+			return err
+		}
+		batch.Queue(addAddressSketch(chainID, hour, data))
+	}
+	for _, height := range heights {
+		batch.Queue(addRollbackLog(chainID, height, undo[height]))
+	}
+	return sendBatch(ctx, tx, batch)
+}
+
+// addressSketch returns sketch of addresses active during the hour, or empty sketch if there were none,
+// the sketch row stays locked until the end of transaction
+func addressSketch(ctx context.Context, q querier, chainID string, hour time.Time) (*hyperloglog.Sketch, error) {
+	res, err := q.Query(ctx, fmt.Sprintf(addressSketchQuery, chainID, hour.Format(Format)))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	sketch := hyperloglog.New()
+	if res.Next() {
+		data := []byte{}
+		err = res.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		err = sketch.UnmarshalBinary(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sketch, res.Err()
+}
+
+// ActiveAddresses estimates number of distinct addresses active in given zones during [from, to)
+func (p *PostgresProcessor) ActiveAddresses(ctx context.Context, chainIDs []string, from, to time.Time) (uint64, error) {
+	zones := ""
+	for _, chainID := range chainIDs {
+		zones += fmt.Sprintf("'%s',", chainID)
+	}
+	if len(zones) == 0 {
+		return 0, nil
+	}
+	zones = zones[:len(zones)-1]
+
+	res, err := p.conn.Query(ctx, fmt.Sprintf(addressSketchesQuery, zones, from.Format(Format), to.Format(Format)))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	total := hyperloglog.New()
+	for res.Next() {
+		data := []byte{}
+		err = res.Scan(&data)
+		if err != nil {
+			return 0, err
+		}
+		sketch := &hyperloglog.Sketch{}
+		err = sketch.UnmarshalBinary(data)
+		if err != nil {
+			return 0, err
+		}
+		err = total.Merge(sketch)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total.Estimate(), res.Err()
+}
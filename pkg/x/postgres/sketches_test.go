@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	processor "github.com/mapofzones/txs-processor/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresProcessor_addressMode(t *testing.T) {
+	p := &PostgresProcessor{}
+	WithSketchZones([]string{"cosmoshub-*", "osmosis-1"})(p)
+
+	assert.Equal(t, AddressesSketch, p.addressMode("cosmoshub-4"))
+	assert.Equal(t, AddressesSketch, p.addressMode("osmosis-1"))
+	assert.Equal(t, AddressesExact, p.addressMode("juno-1"))
+}
+
+func TestPostgresProcessor_CommitSketchAddresses(t *testing.T) {
+	p := &PostgresProcessor{batchBlocks: 10, caughtUpThreshold: time.Minute, sketchZones: []string{"cosmoshub-*"}}
+	hour := blockTime.Truncate(time.Hour)
+
+	for height := int64(1); height <= 2; height++ {
+		p.txStats = &processor.TxStats{
+			ChainID:                 "cosmoshub-4",
+			Hour:                    hour,
+			Senders:                 processor.AddressSet{},
+			Receivers:               processor.AddressSet{},
+			TurnoverAmount:          big.NewInt(0),
+			FailedIBCTransferAmount: big.NewInt(0),
+		}
+		p.txStats.Senders.Add("sender")
+		assert.NoError(t, p.Commit(context.Background(), testBlock{chainID: "cosmoshub-4", height: height, time: blockTime}))
+	}
+
+	// addresses wait for flush instead of exact address queries or a sketch write per block
+	assert.Len(t, p.pending.addresses, 2)
+	assert.Equal(t, int64(2), p.pending.addresses[1].height)
+	assert.Equal(t, hour, p.pending.addresses[1].hour)
+	assert.Equal(t, processor.AddressSet{"sender": struct{}{}}, p.pending.addresses[1].addresses)
+}
@@ -2,43 +2,226 @@ package postgres
 
 // queries that write to db
 
+// zones are enabled only when we process their first block (they might have been added implicitly before),
+// after that is_enabled, name and other columns are managed by operators
 const addZoneQuery = `insert into zones(name, chain_id, is_enabled, is_caught_up) values %s
     on conflict (chain_id) do update
-        set is_enabled = %t;`
+        set is_enabled = zones.is_enabled or not exists (select 1 from blocks_log where zone = excluded.chain_id),
+            is_caught_up = excluded.is_caught_up;`
 
 const addImplicitZoneQuery = `insert into zones(name, chain_id, is_enabled, is_caught_up) values %s
     on conflict (chain_id) do nothing;`
 
+// block log is moved only if it points right before the committed blocks,
+// so replayed or doubly committed blocks don't change anything and can be detected
 const markBlockQuery = `insert into blocks_log(zone, last_processed_block, last_updated_at) values %s
     on conflict (zone) do update
-        set last_processed_block = blocks_log.last_processed_block + 1,
-            last_updated_at = '%s';`
+        set last_processed_block = excluded.last_processed_block,
+            last_updated_at = excluded.last_updated_at
+        where blocks_log.last_processed_block = %d;`
 
-const addClientsQuery = `insert into ibc_clients(zone, client_id, chain_id) values %s
+const addClientsQuery = `insert into ibc_clients(zone, client_id, chain_id, height) values %s
     on conflict (zone, client_id) do nothing;`
 
-const addConnectionsQuery = `insert into ibc_connections(zone, connection_id, client_id) values %s
+const addConnectionsQuery = `insert into ibc_connections(zone, connection_id, client_id, height) values %s
     on conflict (zone, connection_id) do nothing;`
 
-const addChannelsQuery = `insert into ibc_channels(zone, channel_id, connection_id, is_opened) values %s
+const addChannelsQuery = `insert into ibc_channels(zone, channel_id, connection_id, is_opened, state, state_height, state_updated_at, port_id, application, height) values %s
     on conflict(zone, channel_id) do nothing;`
 
 const markChannelQuery = `update ibc_channels
-    set is_opened = %t
+    set is_opened = %t,
+        state = '%s',
+        state_height = %d,
+        state_updated_at = '%s'
         where zone = '%s'
         and channel_id = '%s';`
 
+// creation is recorded only once, so INIT events of channels created by earlier blocks are skipped
+const addChannelEventsQuery = `insert into ibc_channel_events(zone, channel_id, state, height, block_time, tx_hash)
+    select e.zone, e.channel_id, e.state, e.height, e.block_time::timestamp, e.tx_hash
+        from (values %s) as e(zone, channel_id, state, height, block_time, tx_hash)
+        where e.state <> 'INIT'
+            or not exists (select 1 from ibc_channels ch
+                where ch.zone = e.zone
+                    and ch.channel_id = e.channel_id
+                    and coalesce(ch.height, 0) < e.height);`
+
+const addTxStatsQuery = `insert into total_tx_hourly_stats(zone, hour, txs_cnt, txs_w_ibc_xfer_cnt, period, txs_w_ibc_xfer_fail_cnt, total_coin_turnover_amount, txs_fail_cnt, ibc_xfer_fail_amount) values %s
+    on conflict (hour, zone, period) do update
+        set txs_cnt = total_tx_hourly_stats.txs_cnt + excluded.txs_cnt,
+            txs_w_ibc_xfer_cnt = total_tx_hourly_stats.txs_w_ibc_xfer_cnt + excluded.txs_w_ibc_xfer_cnt,
+            txs_w_ibc_xfer_fail_cnt = total_tx_hourly_stats.txs_w_ibc_xfer_fail_cnt + excluded.txs_w_ibc_xfer_fail_cnt,
+            total_coin_turnover_amount = total_tx_hourly_stats.total_coin_turnover_amount + excluded.total_coin_turnover_amount,
+            txs_fail_cnt = total_tx_hourly_stats.txs_fail_cnt + excluded.txs_fail_cnt,
+            ibc_xfer_fail_amount = total_tx_hourly_stats.ibc_xfer_fail_amount + excluded.ibc_xfer_fail_amount;`
+
+const addFailedMsgsStatsQuery = `insert into failed_msgs_hourly_stats(zone, hour, msg_type, msgs_cnt) values %s
+    on conflict (zone, hour, msg_type) do update
+        set msgs_cnt = failed_msgs_hourly_stats.msgs_cnt + excluded.msgs_cnt;`
+
+const addActiveAddressesQuery = `insert into active_addresses(address, zone, hour, period, is_sender, is_receiver, height) values %s
+    on conflict (address, zone, hour, period) do update
+        set is_sender = active_addresses.is_sender or excluded.is_sender,
+            is_receiver = active_addresses.is_receiver or excluded.is_receiver;`
+
+const addZoneAddressesQuery = `insert into zone_addresses(zone, address, first_seen_at, height) values %s
+    on conflict (zone, address) do nothing;`
+
+// only addresses which were not active during the period yet are counted
+const addPeriodActiveAddressesQuery = `with new_addresses as (
+    insert into period_active_addresses(zone, address, period, period_start, height) values %s
+        on conflict (zone, address, period, period_start) do nothing
+        returning zone, period, period_start
+)
+insert into period_active_addresses_stats(zone, period, period_start, addresses_cnt)
+    select zone, period, period_start, count(*) from new_addresses
+        group by zone, period, period_start
+    on conflict (zone, period, period_start) do update
+        set addresses_cnt = period_active_addresses_stats.addresses_cnt + excluded.addresses_cnt;`
+
+const addAddressSketchQuery = `insert into active_addresses_sketches(zone, hour, sketch) values %s
+    on conflict (zone, hour) do update
+        set sketch = excluded.sketch;`
+
+const addIbcAppStatsQuery = `insert into ibc_app_hourly_stats(zone, application, source, destination, hour, txs_cnt) values %s
+    on conflict (zone, application, source, destination, hour) do update
+        set txs_cnt = ibc_app_hourly_stats.txs_cnt + excluded.txs_cnt;`
+
+// rollback log keeps queries which reverse counters added by every block
+const addRollbackLogQuery = `insert into rollback_log(zone, height, query) values %s;`
+
+// channel state is logged as it was before the block changed it, so rollback restores it exactly
+const addChannelStateRollbackLogQuery = `insert into rollback_log(zone, height, query)
+    select zone, %d, format('update ibc_channels set is_opened = %%L, state = %%L, state_height = %%L, state_updated_at = %%L where zone = %%L and channel_id = %%L;',
+            is_opened, state, state_height, state_updated_at, zone, channel_id)
+        from ibc_channels
+        where zone = '%s'
+            and channel_id = '%s';`
+
+// roles which the block adds to addresses of earlier blocks are logged as they were before it,
+// rows created by the block itself are removed by their height
+const addActiveAddressesRollbackLogQuery = `insert into rollback_log(zone, height, query)
+    select a.zone, %d, format('update active_addresses set is_sender = %%L, is_receiver = %%L where address = %%L and zone = %%L and hour = %%L and period = %%L;',
+            a.is_sender, a.is_receiver, a.address, a.zone, a.hour, a.period)
+        from active_addresses a
+        join (values %s) as r(address, is_sender, is_receiver) on r.address = a.address
+        where a.zone = '%s'
+            and a.hour = '%s'
+            and a.period = 1
+            and ((r.is_sender and not a.is_sender) or (r.is_receiver and not a.is_receiver));`
+
+// only recent blocks can be rolled back, older undo queries are removed
+const pruneRollbackLogQuery = `delete from rollback_log where zone = '%s' and height <= %d;`
+
+// queries that roll zone back to the given height
+// they remove rows which were created by later blocks
+const rollbackChannelEventsQuery = `delete from ibc_channel_events where zone = '%s' and height > %d;`
+
+const rollbackChannelsQuery = `delete from ibc_channels where zone = '%s' and height > %d;`
+
+const rollbackConnectionsQuery = `delete from ibc_connections where zone = '%s' and height > %d;`
+
+const rollbackClientsQuery = `delete from ibc_clients where zone = '%s' and height > %d;`
+
+const rollbackActiveAddressesQuery = `delete from active_addresses where zone = '%s' and height > %d;`
+
+const rollbackZoneAddressesQuery = `delete from zone_addresses where zone = '%s' and height > %d;`
+
+const rollbackPeriodActiveAddressesQuery = `with removed as (
+    delete from period_active_addresses where zone = '%s' and height > %d
+        returning zone, period, period_start
+)
+update period_active_addresses_stats s
+    set addresses_cnt = s.addresses_cnt - r.addresses_cnt
+    from (select zone, period, period_start, count(*) as addresses_cnt from removed
+            group by zone, period, period_start) r
+    where s.zone = r.zone
+        and s.period = r.period
+        and s.period_start = r.period_start;`
+
+const rollbackLogQuery = `delete from rollback_log where zone = '%s' and height > %d;`
+
+const rollbackBlocksLogQuery = `update blocks_log
+    set last_processed_block = %d,
+        last_updated_at = '%s'
+    where zone = '%s';`
+
+// queries that bootstrap zone from the given height
+
+// zone is added disabled like implicit zones, it gets enabled when its first block is processed
+const bootstrapZoneQuery = `insert into zones(name, chain_id, is_enabled, is_caught_up, start_height) values %s
+    on conflict (chain_id) do update
+        set start_height = excluded.start_height;`
+
+// processed zone can only skip blocks, moving it back requires rollback
+const bootstrapBlocksLogQuery = `update blocks_log
+    set last_processed_block = %d,
+        last_updated_at = '%s'
+    where zone = '%s'
+        and last_processed_block < %d;`
+
+// chain locks use two key form with these namespaces, so they don't collide with advisory locks of other db users,
+// commit lock has its own namespace, since commits run on pooled connections while ownership is held by another one
+const (
+	chainLockNamespace       = "1836022384"
+	chainCommitLockNamespace = "1836022385"
+)
+
+// session lock is held for as long as processor owns the chain, so other replicas skip its blocks
+const lockChainQuery = `select pg_try_advisory_lock(` + chainLockNamespace + `, hashtext('%s'));`
+
+// transaction lock serializes commits of the chain even if session lock was lost together with connection
+const lockChainTxQuery = `select pg_advisory_xact_lock(` + chainCommitLockNamespace + `, hashtext('%s'));`
+
+// queries are applied from the latest block to the earliest one
+const undoQueriesQuery = `select query from rollback_log
+    where zone = '%s'
+        and height > %d
+    order by height desc;`
+
+const startHeightQuery = `select start_height from zones
+    where chain_id = '%s'
+        and start_height is not null;`
+
 const lastProcessedBlockQuery = `select last_processed_block from blocks_log
     where zone = '%s';`
 
+// channels inserted before states were tracked only have is_opened flag
+const channelStateQuery = `select coalesce(state, case when is_opened then 'OPEN' else 'INIT' end) from ibc_channels
+	where channel_id = '%s'
+		and zone = '%s';`
+
 const chainIDFromClientIDQuery = `select chain_id from ibc_clients
 	where client_id = '%s'
 		and zone = '%s';`
 
-const clientIDFromConnectionIDQuery = `select client_id from ibc_connections
-	where connection_id = '%s'
-		and zone = '%s';`
+const chainIDFromConnectionIDQuery = `select cl.chain_id from ibc_connections co
+	join ibc_clients cl on cl.zone = co.zone
+		and cl.client_id = co.client_id
+	where co.connection_id = '%s'
+		and co.zone = '%s';`
+
+// channels inserted before ports were tracked were all token transfer channels
+const channelsFromChannelIDsQuery = `select ch.channel_id, cl.chain_id, coalesce(ch.port_id, 'transfer') from ibc_channels ch
+	join ibc_connections co on co.zone = ch.zone
+		and co.connection_id = ch.connection_id
+	join ibc_clients cl on cl.zone = co.zone
+		and cl.client_id = co.client_id
+	where ch.zone = '%s'
+		and ch.channel_id in (%s);`
 
-const connectionIDFromChannelIDQuery = `select connection_id from ibc_channels
+const channelEventsQuery = `select state, height, block_time, tx_hash from ibc_channel_events
 	where channel_id = '%s'
-		and zone = '%s';`
+		and zone = '%s'
+	order by height, id;`
+
+const addressSketchQuery = `select sketch from active_addresses_sketches
+	where zone = '%s'
+		and hour = '%s'
+	for update;`
+
+const addressSketchesQuery = `select sketch from active_addresses_sketches
+	where zone in (%s)
+		and hour >= '%s'
+		and hour < '%s';`